@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/SUSE/testbrain/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list [flags] [files...]",
+	Short: "Lists the tests that would run.",
+	Long: `Lists the bash tests that would be run with the same
+flags, in the order they would run, without running them.
+The tests are output as text, as JSON, or as one absolute
+path per line.`,
+	PreRun: bindFlags,
+	Run:    listCommandWithViperArgs,
+}
+
+func init() {
+	RootCmd.AddCommand(listCmd)
+	listCmd.PersistentFlags().String("format", lib.ListFormatText, "Output format: text, json, or paths for one path per line")
+	addSelectionFlags(listCmd.PersistentFlags())
+}
+
+func listCommandWithViperArgs(_ *cobra.Command, testTargets []string) {
+	flagFormat := viper.GetString("format")
+
+	runner := lib.NewRunner(
+		os.Stdout,
+		os.Stderr,
+		selectionOptions(testTargets),
+	)
+	if err := runner.ListCommand(flagFormat); err != nil {
+		os.Exit(1)
+	}
+}
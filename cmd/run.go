@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/SUSE/testbrain/lib"
@@ -11,6 +13,10 @@ import (
 	"github.com/spf13/viper"
 )
 
+// interruptedExitCode is the exit code when the run is interrupted, the same
+// a shell uses for a command terminated by SIGINT.
+const interruptedExitCode = 130
+
 // runCmd represents the run command
 var runCmd = &cobra.Command{
 	Use:   "run [flags] [files...]",
@@ -19,69 +25,156 @@ var runCmd = &cobra.Command{
 and summarizing it. If no files are given, the current
 working directory is assumed.  Any directories will be
 walked recursively.`,
-	Run: runCommandWithViperArgs,
+	PreRun: bindFlags,
+	Run:    runCommandWithViperArgs,
 }
 
 func init() {
 	RootCmd.AddCommand(runCmd)
 	runCmd.PersistentFlags().Int("timeout", 300, "Timeout (in seconds) for each individual test")
 	runCmd.PersistentFlags().Bool("json", false, "Output in JSON format")
+	runCmd.PersistentFlags().Bool("json-stream", false, "Output a stream of JSON events, one per line, while the tests run")
+	runCmd.PersistentFlags().String("format", "text", "Output format: text, json, json-stream, or tap for a TAP version 13 stream")
+	runCmd.PersistentFlags().Bool("parse-tap", false, "Parse the TAP the tests print on stdout into sub-test results")
+	runCmd.PersistentFlags().String("junit", "", "Also write a JUnit XML report to the given file")
+	runCmd.PersistentFlags().String("html", "", "Also write a self-contained HTML report to the given file")
+	runCmd.PersistentFlags().String("markdown", "", "Also write a Markdown summary to the given file")
+	runCmd.PersistentFlags().String("results-dir", "", "Write the logs and the artifacts of each test, and the results in JSON, to the given directory")
+	runCmd.PersistentFlags().String("ci-annotations", "", "Also output the annotations of a CI system: github for GitHub Actions, or teamcity")
+	runCmd.PersistentFlags().Bool("github-step-summary", false, "Also append the Markdown summary to the GitHub Actions job summary in $GITHUB_STEP_SUMMARY")
 	runCmd.PersistentFlags().BoolP("verbose", "v", false, "Output the progress of running tests")
-	runCmd.PersistentFlags().String("include", "_test\\.sh$", "Regular expression of subset of tests to run")
-	runCmd.PersistentFlags().String("exclude", "^$", "Regular expression of subset of tests to not run, applied after --include")
-	runCmd.PersistentFlags().Bool("in-order", false, "Do not randomize test order")
-	runCmd.PersistentFlags().Int64("seed", -1, "Random seed used to determine the order of tests")
 	runCmd.PersistentFlags().BoolP("dry-run", "n", false, "Do not actually run the tests")
-
-	viper.BindPFlags(runCmd.PersistentFlags())
+	runCmd.PersistentFlags().IntP("jobs", "j", 1, "Number of tests to run concurrently")
+	runCmd.PersistentFlags().Int("retries", 0, "Number of times a failed test is retried")
+	runCmd.PersistentFlags().Bool("fail-on-flaky", false, "Fail the run when a test only passed after being retried")
+	runCmd.PersistentFlags().Bool("strict-requirements", false, "Fail the tests whose requirements are not met, instead of skipping them")
+	runCmd.PersistentFlags().Int("kill-grace-period", 10, "Time (in seconds) a timed out test is given to exit after SIGTERM before it is killed")
+	runCmd.PersistentFlags().Bool("watch", false, "Run the tests again whenever they change, until interrupted")
+	addSelectionFlags(runCmd.PersistentFlags())
 }
 
 func runCommandWithViperArgs(_ *cobra.Command, testTargets []string) {
 	timeoutInSeconds := viper.GetInt("timeout")
 	flagTimeout := time.Duration(timeoutInSeconds) * time.Second
 	flagJSONOutput := viper.GetBool("json")
+	flagJSONStreamOutput := viper.GetBool("json-stream")
+	flagFormat := viper.GetString("format")
+	flagJUnitFile := viper.GetString("junit")
+	flagHTMLFile := viper.GetString("html")
+	flagMarkdownFile := viper.GetString("markdown")
+	flagGitHubStepSummary := viper.GetBool("github-step-summary")
+	flagCIAnnotations := viper.GetString("ci-annotations")
+	flagResultsDir := viper.GetString("results-dir")
+	flagParseTAP := viper.GetBool("parse-tap")
 	flagVerbose := viper.GetBool("verbose")
-	flagInclude := viper.GetString("include")
-	flagExclude := viper.GetString("exclude")
-	flagInOrder := viper.GetBool("in-order")
-	flagSeed := viper.GetInt64("seed")
 	flagDryRun := viper.GetBool("dry-run")
+	flagJobs := viper.GetInt("jobs")
+	flagRetries := viper.GetInt("retries")
+	flagFailOnFlaky := viper.GetBool("fail-on-flaky")
+	flagStrictRequirements := viper.GetBool("strict-requirements")
+	flagKillGracePeriod := time.Duration(viper.GetInt("kill-grace-period")) * time.Second
+	flagWatch := viper.GetBool("watch")
 
-	if flagInOrder && flagSeed != -1 {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("Cannot set --in-order and --seed at the same time"))
+	if flagJSONOutput && flagJSONStreamOutput {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("Cannot set --json and --json-stream at the same time"))
 		os.Exit(1)
 	}
-	if flagSeed == -1 {
-		flagSeed = time.Now().UnixNano()
+	if (flagJSONOutput || flagJSONStreamOutput) && flagFormat != "text" {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("Cannot set --format with --json or --json-stream"))
+		os.Exit(1)
 	}
-
-	if len(testTargets) == 0 {
-		// No testTargets given, current working directory is assumed.
-		cwd, err := os.Getwd()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	flagTAPOutput := false
+	switch flagFormat {
+	case "text":
+	case "json":
+		flagJSONOutput = true
+	case "json-stream":
+		flagJSONStreamOutput = true
+	case "tap":
+		flagTAPOutput = true
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", fmt.Errorf("Unknown format %q, expected text, json, json-stream or tap", flagFormat))
+		os.Exit(1)
+	}
+	if flagWatch && (flagJSONOutput || flagJSONStreamOutput || flagTAPOutput || flagDryRun) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("Cannot set --watch with an output format other than text, or with --dry-run"))
+		os.Exit(1)
+	}
+	switch flagCIAnnotations {
+	case "", lib.CIAnnotationsGitHub, lib.CIAnnotationsTeamCity:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", fmt.Errorf("Unknown CI annotations %q, expected github or teamcity", flagCIAnnotations))
+		os.Exit(1)
+	}
+	stepSummaryFile := ""
+	if flagGitHubStepSummary {
+		stepSummaryFile = os.Getenv("GITHUB_STEP_SUMMARY")
+		if stepSummaryFile == "" {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("Cannot set --github-step-summary when GITHUB_STEP_SUMMARY is not set"))
 			os.Exit(1)
 		}
-		testTargets = []string{cwd}
 	}
-
-	options := lib.RunnerOptions{
-		TestTargets:  testTargets,
-		IncludeReStr: flagInclude,
-		ExcludeReStr: flagExclude,
-		Timeout:      flagTimeout,
-		InOrder:      flagInOrder,
-		RandomSeed:   flagSeed,
-		JSONOutput:   flagJSONOutput,
-		Verbose:      flagVerbose,
-		DryRun:       flagDryRun,
+	if flagJobs < 1 {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("--jobs must be at least 1"))
+		os.Exit(1)
 	}
+	if flagRetries < 0 {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("--retries cannot be negative"))
+		os.Exit(1)
+	}
+
+	options := selectionOptions(testTargets)
+	options.Timeout = flagTimeout
+	options.JSONOutput = flagJSONOutput
+	options.JSONStreamOutput = flagJSONStreamOutput
+	options.TAPOutput = flagTAPOutput
+	options.ParseTAP = flagParseTAP
+	options.Verbose = flagVerbose
+	options.DryRun = flagDryRun
+	options.JUnitFile = flagJUnitFile
+	options.HTMLFile = flagHTMLFile
+	options.MarkdownFile = flagMarkdownFile
+	options.StepSummaryFile = stepSummaryFile
+	options.CIAnnotations = flagCIAnnotations
+	options.ResultsDir = flagResultsDir
+	options.Parallelism = flagJobs
+	options.KillGracePeriod = flagKillGracePeriod
+	options.Retries = flagRetries
+	options.FailOnFlaky = flagFailOnFlaky
+	options.StrictRequirements = flagStrictRequirements
 	runner := lib.NewRunner(
 		os.Stdout,
 		os.Stderr,
 		options,
 	)
-	if err := runner.RunCommand(); err != nil {
+	handleInterrupts(runner)
+	command := runner.RunCommand
+	if flagWatch {
+		command = runner.WatchCommand
+	}
+	if err := command(); err != nil {
+		if err == lib.ErrInterrupted {
+			os.Exit(interruptedExitCode)
+		}
 		os.Exit(1)
 	}
 }
+
+// handleInterrupts stops the runner gracefully on the first SIGINT or SIGTERM,
+// so that the results of the tests that completed are still output, and quits
+// right away on the second one.
+func handleInterrupts(runner *lib.Runner) {
+	// termui installs its own SIGINT handler, which exits right away; it must
+	// be removed for the runner to get a chance to stop.
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "Interrupted, stopping the tests. Interrupt again to quit immediately.")
+		runner.Interrupt()
+		<-signals
+		runner.Kill()
+		os.Exit(interruptedExitCode)
+	}()
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SUSE/testbrain/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// addSelectionFlags adds the flags selecting the tests and their order, which
+// are shared by the commands that look for tests.
+func addSelectionFlags(flags *pflag.FlagSet) {
+	flags.String("include", "_test\\.sh$", "Regular expression of subset of tests to run")
+	flags.String("exclude", "^$", "Regular expression of subset of tests to not run, applied after --include")
+	flags.String("tags", "", "Boolean expression of tags of tests to run, such as 'smoke && !slow'")
+	flags.Bool("in-order", false, "Do not randomize test order")
+	flags.Int64("seed", -1, "Random seed used to determine the order of tests")
+	flags.Int("shard-index", 0, "Index of the shard of tests to run, from 0 to --shard-total - 1")
+	flags.Int("shard-total", 1, "Number of shards to split the tests into, to run them on several nodes")
+	flags.String("balance-from", "", "Balance the shards using the durations in the JSON output of an earlier run")
+	flags.String("rerun-failed", "", "Only run the tests that failed in the JSON output of an earlier run")
+	flags.String("rerun-status", "failed", "Comma separated statuses of the tests to run with --rerun-failed: passed, flaky, skipped, failed or notRun")
+	flags.Bool("rerun-same-seed", false, "Use the seed of the run given to --rerun-failed")
+	flags.String("setup-script", "setup.sh", "Name of the scripts run before the tests of their directory")
+	flags.String("teardown-script", "teardown.sh", "Name of the scripts run after the tests of their directory")
+}
+
+// bindFlags binds the flags of the command being run to viper.  The commands
+// share some flags, so only the ones of the command being run are bound.
+func bindFlags(cmd *cobra.Command, _ []string) {
+	viper.BindPFlags(cmd.PersistentFlags())
+}
+
+// selectionOptions returns the options of the runner selecting the tests and
+// their order, from the flags added by addSelectionFlags.  It exits if they
+// are not valid.
+func selectionOptions(testTargets []string) lib.RunnerOptions {
+	flagInclude := viper.GetString("include")
+	flagExclude := viper.GetString("exclude")
+	flagTags := viper.GetString("tags")
+	flagInOrder := viper.GetBool("in-order")
+	flagSeed := viper.GetInt64("seed")
+	flagShardIndex := viper.GetInt("shard-index")
+	flagShardTotal := viper.GetInt("shard-total")
+	flagBalanceFrom := viper.GetString("balance-from")
+	flagRerunFrom := viper.GetString("rerun-failed")
+	flagRerunStatuses := strings.Split(strings.Replace(viper.GetString("rerun-status"), " ", "", -1), ",")
+	flagRerunSameSeed := viper.GetBool("rerun-same-seed")
+	flagSetupScript := viper.GetString("setup-script")
+	flagTeardownScript := viper.GetString("teardown-script")
+
+	if flagInOrder && flagSeed != -1 {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("Cannot set --in-order and --seed at the same time"))
+		os.Exit(1)
+	}
+	if flagShardTotal < 1 {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("--shard-total must be at least 1"))
+		os.Exit(1)
+	}
+	if flagShardIndex < 0 || flagShardIndex >= flagShardTotal {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", fmt.Errorf("--shard-index must be between 0 and %d", flagShardTotal-1))
+		os.Exit(1)
+	}
+	if flagBalanceFrom != "" && flagShardTotal < 2 {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("--balance-from requires --shard-total"))
+		os.Exit(1)
+	}
+	if flagRerunSameSeed && flagRerunFrom == "" {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("--rerun-same-seed requires --rerun-failed"))
+		os.Exit(1)
+	}
+	if flagRerunSameSeed && (flagInOrder || flagSeed != -1) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", errors.New("Cannot set --rerun-same-seed with --in-order or --seed"))
+		os.Exit(1)
+	}
+	if flagSeed == -1 {
+		flagSeed = time.Now().UnixNano()
+	}
+
+	if len(testTargets) == 0 {
+		// No testTargets given, current working directory is assumed.
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		testTargets = []string{cwd}
+	}
+
+	return lib.RunnerOptions{
+		TestTargets:    testTargets,
+		IncludeReStr:   flagInclude,
+		ExcludeReStr:   flagExclude,
+		TagsExpr:       flagTags,
+		InOrder:        flagInOrder,
+		RandomSeed:     flagSeed,
+		SetupScript:    flagSetupScript,
+		TeardownScript: flagTeardownScript,
+		ShardIndex:     flagShardIndex,
+		ShardTotal:     flagShardTotal,
+		BalanceFrom:    flagBalanceFrom,
+		RerunFrom:      flagRerunFrom,
+		RerunStatuses:  flagRerunStatuses,
+		RerunSameSeed:  flagRerunSameSeed,
+	}
+}
@@ -0,0 +1,310 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CI systems whose annotations can be written with CIAnnotations.
+const (
+	CIAnnotationsGitHub   = "github"
+	CIAnnotationsTeamCity = "teamcity"
+)
+
+// ciAnnotationOutputLines is the number of lines of output of a failed test
+// shown in its GitHub annotation; the whole output is in the log.
+const ciAnnotationOutputLines = 20
+
+// newCIAnnotations returns the reporter writing the annotations of a CI
+// system.  They are written to stdout along with the text output, and to
+// stderr when the output is in another format, which the CI systems read as
+// well.
+func newCIAnnotations(r *Runner) Reporter {
+	writer := r.stdout
+	if !r.textOutput() {
+		writer = r.stderr
+	}
+	if r.options.CIAnnotations == CIAnnotationsTeamCity {
+		return newTeamCityMessages(writer)
+	}
+	return &githubAnnotations{writer: writer}
+}
+
+// githubAnnotations writes the workflow commands of GitHub Actions, which show
+// the failed tests as errors, and the flaky, skipped and interrupted tests as
+// warnings and notices, in the summary of the workflow run.  It is safe for use
+// by several goroutines.
+type githubAnnotations struct {
+	mutex    sync.Mutex
+	writer   io.Writer
+	testRoot string
+}
+
+func (a *githubAnnotations) RunStart(run RunInfo) {
+	a.testRoot = run.TestRoot
+}
+
+// TestStart writes a debug message, which GitHub only shows when the debug
+// logging of the steps is enabled.
+func (a *githubAnnotations) TestStart(index int, testFile string) {
+	a.command("debug", nil, fmt.Sprintf("Running test %s", testFile))
+}
+
+func (a *githubAnnotations) TestOutput(string, []byte) {}
+
+func (a *githubAnnotations) TestRetry(index int, attempt FailedResult) {
+	a.annotate("warning", attempt.TestFile, fmt.Sprintf("%s failed with %s, retrying", attempt.TestFile, attempt.reason()), "")
+}
+
+func (a *githubAnnotations) TestEnd(test FinishedTest) {
+	result := test.Result
+	switch test.Status {
+	case StatusFailed:
+		failed := FailedResult{TestResult: result, ExitCode: test.ExitCode}
+		a.annotate("error", result.TestFile, fmt.Sprintf("%s failed with %s", result.TestFile, failed.reason()), annotationDetails(result))
+	case StatusFlaky:
+		a.annotate("warning", result.TestFile, fmt.Sprintf("%s is flaky, it passed on attempt %d", result.TestFile, len(result.FailedAttempts)+1), "")
+	case StatusSkipped:
+		title := fmt.Sprintf("%s was skipped", result.TestFile)
+		if result.SkipReason != "" {
+			title += ": " + result.SkipReason
+		}
+		a.annotate("notice", result.TestFile, title, "")
+	case StatusNotRun:
+		a.annotate("warning", result.TestFile, fmt.Sprintf("%s did not complete, the run was interrupted", result.TestFile), "")
+	}
+}
+
+func (a *githubAnnotations) HookStart(string, string) {}
+
+func (a *githubAnnotations) HookEnd(result HookResult) {
+	if result.failed() {
+		a.annotate("error", result.TestFile, fmt.Sprintf("%s hook %s %s", result.Hook, result.TestFile, result.status()), annotationDetails(result.TestResult))
+	}
+}
+
+func (a *githubAnnotations) RunEnd(Results) error {
+	return nil
+}
+
+// annotate writes an annotation about a test, with its details, if any, below
+// the title.
+func (a *githubAnnotations) annotate(level string, testFile string, title string, details string) {
+	message := title
+	if details != "" {
+		message += "\n\n" + details
+	}
+	properties := [][2]string{
+		{"file", a.annotatedFile(testFile)},
+		{"title", title},
+	}
+	a.command(level, properties, message)
+}
+
+// annotationDetails returns the end of the output of a failed test, and where
+// its logs and artifacts are in the results directory.
+func annotationDetails(result TestResult) string {
+	var details []string
+	if output := strings.TrimRight(result.Output, "\n"); output != "" {
+		lines := strings.Split(output, "\n")
+		if len(lines) > ciAnnotationOutputLines {
+			lines = lines[len(lines)-ciAnnotationOutputLines:]
+		}
+		details = append(details, strings.Join(lines, "\n"))
+	}
+	if result.ResultsDir != "" {
+		details = append(details, "Logs: "+filepath.Join(result.ResultsDir, outputLogName))
+	}
+	if len(result.Artifacts) > 0 {
+		details = append(details, "Artifacts: "+strings.Join(result.artifactPaths(), ", "))
+	}
+	return strings.Join(details, "\n\n")
+}
+
+// annotatedFile returns the path of a test relative to the working directory,
+// which is the root of the repository in GitHub Actions, so that GitHub can
+// link the annotations to the test.
+func (a *githubAnnotations) annotatedFile(testFile string) string {
+	return workDirPath(filepath.Join(a.testRoot, testFile))
+}
+
+// workDirPath returns a path relative to the working directory if it is below
+// it, and as is otherwise.
+func workDirPath(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.ToSlash(path)
+	}
+	if workDir, err := os.Getwd(); err == nil {
+		if relPath, err := filepath.Rel(workDir, path); err == nil && !strings.HasPrefix(relPath, "..") {
+			path = relPath
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// command writes a workflow command, escaping its properties and message.
+func (a *githubAnnotations) command(name string, properties [][2]string, message string) {
+	line := "::" + name
+	for i, property := range properties {
+		if i == 0 {
+			line += " "
+		} else {
+			line += ","
+		}
+		line += property[0] + "=" + githubPropertyEscaper.Replace(property[1])
+	}
+	line += "::" + githubMessageEscaper.Replace(message) + "\n"
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	io.WriteString(a.writer, line)
+}
+
+var githubMessageEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+var githubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+// teamCityMessages writes the service messages of TeamCity, which reports each
+// test script as a test of the build, with its output.  The messages of each
+// test have its path as flow id, so that TeamCity tells apart the tests that
+// run concurrently.  It is safe for use by several goroutines.
+type teamCityMessages struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	// suiteStarted is set once the test suite of the run, or of the current
+	// round of tests of WatchCommand, was started.
+	suiteStarted bool
+	// started holds the tests that were started and are not done yet.
+	started map[string]bool
+}
+
+// teamCitySuite is the name of the test suite the tests are reported in.
+const teamCitySuite = "testbrain"
+
+func newTeamCityMessages(writer io.Writer) *teamCityMessages {
+	return &teamCityMessages{
+		writer:  writer,
+		started: make(map[string]bool),
+	}
+}
+
+func (m *teamCityMessages) RunStart(RunInfo) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.startSuite()
+}
+
+func (m *teamCityMessages) TestStart(index int, testFile string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.startTest(testFile)
+}
+
+func (m *teamCityMessages) TestOutput(string, []byte) {}
+
+func (m *teamCityMessages) TestRetry(index int, attempt FailedResult) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.message("message", attempt.TestFile,
+		"text", fmt.Sprintf("%s failed with %s, retrying", attempt.TestFile, attempt.reason()),
+		"status", "WARNING")
+}
+
+func (m *teamCityMessages) TestEnd(test FinishedTest) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := test.Result
+	if !m.started[result.TestFile] {
+		// The test was skipped or failed without being run.
+		m.startTest(result.TestFile)
+	}
+	if result.Output != "" {
+		m.message("testStdOut", result.TestFile, "name", result.TestFile, "out", result.Output)
+	}
+	for _, path := range result.artifactPaths() {
+		m.message("testMetadata", result.TestFile, "testName", result.TestFile, "type", "artifact", "value", workDirPath(path))
+	}
+	switch test.Status {
+	case StatusFailed:
+		failed := FailedResult{TestResult: result, ExitCode: test.ExitCode}
+		m.message("testFailed", result.TestFile, "name", result.TestFile, "message", "Failed with "+failed.reason())
+	case StatusSkipped:
+		message := "Skipped"
+		if result.SkipReason != "" {
+			message += ", " + result.SkipReason
+		}
+		m.message("testIgnored", result.TestFile, "name", result.TestFile, "message", message)
+	case StatusNotRun:
+		m.message("testIgnored", result.TestFile, "name", result.TestFile, "message", "Not run, the test run was interrupted")
+	}
+	m.message("testFinished", result.TestFile,
+		"name", result.TestFile,
+		"duration", fmt.Sprintf("%d", result.Duration/time.Millisecond))
+	delete(m.started, result.TestFile)
+}
+
+func (m *teamCityMessages) HookStart(string, string) {}
+
+func (m *teamCityMessages) HookEnd(result HookResult) {
+	if !result.failed() {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.message("message", result.TestFile,
+		"text", fmt.Sprintf("%s hook %s %s", result.Hook, result.TestFile, result.status()),
+		"errorDetails", result.Output,
+		"status", "ERROR")
+}
+
+func (m *teamCityMessages) RunEnd(Results) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.suiteStarted {
+		m.message("testSuiteFinished", "", "name", teamCitySuite)
+		m.suiteStarted = false
+	}
+	return nil
+}
+
+func (m *teamCityMessages) startSuite() {
+	if !m.suiteStarted {
+		m.message("testSuiteStarted", "", "name", teamCitySuite)
+		m.suiteStarted = true
+	}
+}
+
+func (m *teamCityMessages) startTest(testFile string) {
+	m.startSuite()
+	m.message("testStarted", testFile, "name", testFile, "captureStandardOutput", "false")
+	m.started[testFile] = true
+}
+
+// message writes a service message with the given attributes, as names
+// followed by their values, and the flow id of a test, if any.
+func (m *teamCityMessages) message(name string, flowID string, attributes ...string) {
+	line := "##teamcity[" + name
+	for i := 0; i+1 < len(attributes); i += 2 {
+		line += fmt.Sprintf(" %s='%s'", attributes[i], teamCityEscaper.Replace(attributes[i+1]))
+	}
+	if flowID != "" {
+		line += fmt.Sprintf(" flowId='%s'", teamCityEscaper.Replace(flowID))
+	}
+	fmt.Fprintln(m.writer, line+"]")
+}
+
+var teamCityEscaper = strings.NewReplacer(
+	"|", "||",
+	"'", "|'",
+	"\n", "|n",
+	"\r", "|r",
+	"[", "|[",
+	"]", "|]",
+	"\u0085", "|x",
+	"\u2028", "|l",
+	"\u2029", "|p",
+)
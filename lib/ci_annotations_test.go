@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// annotationLines returns the lines of the output that are CI annotations.
+func annotationLines(output string, prefix string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestRunCommandGitHubAnnotations(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.CIAnnotations = CIAnnotationsGitHub
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tests are not below the working directory, so their paths are
+	// absolute.
+	file := filepath.ToSlash(testFolder)
+	file = strings.Replace(file, ":", "%3A", -1)
+	expected := []string{
+		"::debug::Running test fail_test.sh",
+		"::error file=" + file + "/fail_test.sh,title=fail_test.sh failed with exit code 42::fail_test.sh failed with exit code 42%0A%0AGoodbye World!",
+		"::debug::Running test skip_test.sh",
+		"::notice file=" + file + "/skip_test.sh,title=skip_test.sh was skipped%3A Nothing to test here::skip_test.sh was skipped: Nothing to test here",
+		"::debug::Running test success_test.sh",
+	}
+	if lines := annotationLines(string(stdoutBytes), "::"); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("\nExpected annotations:\n%s\nHave:\n%s\n", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestRunCommandTeamCityMessages(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	var stdout, stderr concurrentBuffer
+	r := setupDefaultRunner(&stdout, &stderr)
+	r.options.InOrder = true
+	r.options.JSONOutput = true
+	r.options.CIAnnotations = CIAnnotationsTeamCity
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	// The messages go to stderr, so that the JSON output stays valid.
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stdoutBytes), "##teamcity") {
+		t.Errorf("Expected no service messages in the JSON output, have:\n%s", stdoutBytes)
+	}
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	durationRe := regexp.MustCompile(`duration='\d+'`)
+	stderrStr := durationRe.ReplaceAllString(string(stderrBytes), "duration='DURATION'")
+	expected := []string{
+		"##teamcity[testSuiteStarted name='testbrain']",
+		"##teamcity[testStarted name='fail_test.sh' captureStandardOutput='false' flowId='fail_test.sh']",
+		"##teamcity[testStdOut name='fail_test.sh' out='Goodbye World!|n' flowId='fail_test.sh']",
+		"##teamcity[testFailed name='fail_test.sh' message='Failed with exit code 42' flowId='fail_test.sh']",
+		"##teamcity[testFinished name='fail_test.sh' duration='DURATION' flowId='fail_test.sh']",
+		"##teamcity[testStarted name='skip_test.sh' captureStandardOutput='false' flowId='skip_test.sh']",
+		"##teamcity[testStdOut name='skip_test.sh' out='Something stdout|nSomething stderr|n' flowId='skip_test.sh']",
+		"##teamcity[testIgnored name='skip_test.sh' message='Skipped, Nothing to test here' flowId='skip_test.sh']",
+		"##teamcity[testFinished name='skip_test.sh' duration='DURATION' flowId='skip_test.sh']",
+		"##teamcity[testStarted name='success_test.sh' captureStandardOutput='false' flowId='success_test.sh']",
+		"##teamcity[testStdOut name='success_test.sh' out='Hello World!|n' flowId='success_test.sh']",
+		"##teamcity[testFinished name='success_test.sh' duration='DURATION' flowId='success_test.sh']",
+		"##teamcity[testSuiteFinished name='testbrain']",
+	}
+	if lines := annotationLines(stderrStr, "##teamcity"); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("\nExpected service messages:\n%s\nHave:\n%s\n", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestTeamCityMessagesNotStarted(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	m := newTeamCityMessages(&out)
+	m.TestEnd(FinishedTest{
+		Status:   StatusFailed,
+		ExitCode: unknownExitCode,
+		Result: TestResult{
+			TestFile:          "dir/it's [cf]_test.sh",
+			UnmetRequirements: []string{"cf is not in the PATH"},
+		},
+	})
+	m.RunEnd(Results{})
+
+	expected := "##teamcity[testSuiteStarted name='testbrain']\n" +
+		"##teamcity[testStarted name='dir/it|'s |[cf|]_test.sh' captureStandardOutput='false' flowId='dir/it|'s |[cf|]_test.sh']\n" +
+		"##teamcity[testFailed name='dir/it|'s |[cf|]_test.sh' message='Failed with unmet requirements: cf is not in the PATH' flowId='dir/it|'s |[cf|]_test.sh']\n" +
+		"##teamcity[testFinished name='dir/it|'s |[cf|]_test.sh' duration='0' flowId='dir/it|'s |[cf|]_test.sh']\n" +
+		"##teamcity[testSuiteFinished name='testbrain']\n"
+	if out.String() != expected {
+		t.Errorf("\nExpected service messages:\n%s\nHave:\n%s\n", expected, out.String())
+	}
+}
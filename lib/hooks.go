@@ -0,0 +1,322 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of hooks.
+const (
+	setupHook    = "setup"
+	teardownHook = "teardown"
+)
+
+// hookSet holds the setup and teardown hooks of the directories of a run.  A
+// setup hook runs before the first test beneath its directory, and the
+// teardown hook once the last of them is done, so that the hooks of nested
+// directories run inside the ones of their parents.
+type hookSet struct {
+	mutex sync.Mutex
+	// dirs holds the directories with hooks by their path relative to the
+	// test root; directories without hooks are kept as nil.
+	dirs    map[string]*hookDir
+	results []HookResult
+}
+
+// hookDir is a directory with a setup or a teardown hook.
+type hookDir struct {
+	setup    string
+	teardown string
+	// remaining is the number of tests beneath the directory that are not
+	// done yet.
+	remaining int
+	// entered is set once a test beneath the directory was started, so its
+	// teardown hook has to run.
+	entered  bool
+	tornDown bool
+
+	// setupLock is held while the setup hook runs, so that tests wait for
+	// it to be done.
+	setupLock    sync.Mutex
+	setupDone    bool
+	setupFailure string
+}
+
+// findHooks looks for the hooks of the directories of the tests, from the
+// test root down to the directory of each test.
+func (r *Runner) findHooks(testFolder string, testFiles []string) *hookSet {
+	hooks := &hookSet{dirs: make(map[string]*hookDir)}
+	for _, testFile := range testFiles {
+		for _, dir := range parentDirs(testFile) {
+			hookDir, found := hooks.dirs[dir]
+			if !found {
+				hookDir = r.findHookDir(testFolder, dir)
+				hooks.dirs[dir] = hookDir
+			}
+			if hookDir != nil {
+				hookDir.remaining++
+			}
+		}
+	}
+	return hooks
+}
+
+// findHookDir returns the hooks of a directory, or nil if it has none.
+func (r *Runner) findHookDir(testFolder string, dir string) *hookDir {
+	hookDir := &hookDir{}
+	if r.options.SetupScript != "" {
+		setup := filepath.Join(dir, r.options.SetupScript)
+		if isFile(filepath.Join(testFolder, setup)) {
+			hookDir.setup = setup
+		}
+	}
+	if r.options.TeardownScript != "" {
+		teardown := filepath.Join(dir, r.options.TeardownScript)
+		if isFile(filepath.Join(testFolder, teardown)) {
+			hookDir.teardown = teardown
+		}
+	}
+	if hookDir.setup == "" && hookDir.teardown == "" {
+		return nil
+	}
+	return hookDir
+}
+
+// isHookScript tells whether a file found while looking for tests is a hook.
+func (r *Runner) isHookScript(path string) bool {
+	name := filepath.Base(path)
+	return (r.options.SetupScript != "" && name == r.options.SetupScript) ||
+		(r.options.TeardownScript != "" && name == r.options.TeardownScript)
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// parentDirs returns the directories above a test, relative to the test root,
+// starting with the test root itself.
+func parentDirs(testFile string) []string {
+	dirs := []string{"."}
+	dir := filepath.Dir(testFile)
+	if dir == "." {
+		return dirs
+	}
+	path := ""
+	for _, part := range strings.Split(filepath.ToSlash(dir), "/") {
+		path = filepath.Join(path, part)
+		dirs = append(dirs, path)
+	}
+	return dirs
+}
+
+// dirDepth returns how deep a directory is below the test root.
+func dirDepth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(dir), "/") + 1
+}
+
+// hookDirsOf returns the directories with hooks above a test, outermost first.
+func (hooks *hookSet) hookDirsOf(testFile string) []*hookDir {
+	var hookDirs []*hookDir
+	for _, dir := range parentDirs(testFile) {
+		if hookDir := hooks.dirs[dir]; hookDir != nil {
+			hookDirs = append(hookDirs, hookDir)
+		}
+	}
+	return hookDirs
+}
+
+// scripts returns the hooks of the run, sorted by path, and setup before
+// teardown in each directory.
+func (hooks *hookSet) scripts() []string {
+	var dirs []string
+	for dir, hookDir := range hooks.dirs {
+		if hookDir != nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	var scripts []string
+	for _, dir := range dirs {
+		hookDir := hooks.dirs[dir]
+		if hookDir.setup != "" {
+			scripts = append(scripts, hookDir.setup)
+		}
+		if hookDir.teardown != "" {
+			scripts = append(scripts, hookDir.teardown)
+		}
+	}
+	return scripts
+}
+
+// runTestWithHooks runs the setup hooks a test depends on, then the test
+// itself unless one of them failed, and then the teardown hooks that are no
+// longer needed by the other tests.  A test whose requirements are not met is
+// not run, and neither are its setup hooks.
+func (r *Runner) runTestWithHooks(hooks *hookSet, i int, testFiles []string, testFolder string) testRun {
+	testFile := testFiles[i]
+	defer r.runTeardownHooks(hooks, testFile, testFolder)
+
+	if unmet := r.unmetRequirements(testFile); len(unmet) > 0 {
+		return r.skipOrFailTest(i, testFile, unmet)
+	}
+	skipReason := r.runSetupHooks(hooks, testFile, testFolder)
+	if r.isInterrupted() {
+		return testRun{result: TestResult{TestFile: testFile}, exitCode: interruptedExitCode}
+	}
+	if skipReason != "" {
+		return r.skipTest(i, testFile, skipReason)
+	}
+	return r.runTestAt(i, testFiles, testFolder)
+}
+
+// runSetupHooks runs the setup hooks above a test that did not run yet.  It
+// returns why the test has to be skipped if one of them failed.
+func (r *Runner) runSetupHooks(hooks *hookSet, testFile string, testFolder string) string {
+	for _, hookDir := range hooks.hookDirsOf(testFile) {
+		hookDir.setupLock.Lock()
+		if !hookDir.setupDone {
+			if r.isInterrupted() {
+				hookDir.setupLock.Unlock()
+				return ""
+			}
+			hooks.mutex.Lock()
+			hookDir.entered = true
+			hooks.mutex.Unlock()
+			if hookDir.setup != "" {
+				result := r.runHook(hooks, setupHook, hookDir.setup, testFolder)
+				if result.ExitCode != 0 {
+					hookDir.setupFailure = fmt.Sprintf("setup %s failed with exit code %d", hookDir.setup, result.ExitCode)
+				}
+			}
+			hookDir.setupDone = true
+		}
+		setupFailure := hookDir.setupFailure
+		hookDir.setupLock.Unlock()
+		if setupFailure != "" {
+			return setupFailure
+		}
+	}
+	return ""
+}
+
+// runTeardownHooks marks a test as done, and runs the teardown hooks above it
+// once all the tests beneath them are done, innermost first.
+func (r *Runner) runTeardownHooks(hooks *hookSet, testFile string, testFolder string) {
+	hookDirs := hooks.hookDirsOf(testFile)
+	for i := len(hookDirs) - 1; i >= 0; i-- {
+		hookDir := hookDirs[i]
+		hooks.mutex.Lock()
+		hookDir.remaining--
+		due := hookDir.remaining == 0 && hookDir.entered && !hookDir.tornDown
+		if due {
+			hookDir.tornDown = true
+		}
+		hooks.mutex.Unlock()
+		if due && hookDir.teardown != "" {
+			r.runHook(hooks, teardownHook, hookDir.teardown, testFolder)
+		}
+	}
+}
+
+// runRemainingTeardownHooks runs the teardown hooks that are still due once
+// all the tests are done, which happens when the run is interrupted.
+func (r *Runner) runRemainingTeardownHooks(hooks *hookSet, testFolder string) {
+	var dirs []string
+	for dir, hookDir := range hooks.dirs {
+		if hookDir != nil && hookDir.entered && !hookDir.tornDown {
+			dirs = append(dirs, dir)
+		}
+	}
+	// Deeper directories first, so nested hooks still run inside the ones of
+	// their parents.
+	sort.Slice(dirs, func(i, j int) bool {
+		depthI, depthJ := dirDepth(dirs[i]), dirDepth(dirs[j])
+		if depthI != depthJ {
+			return depthI > depthJ
+		}
+		return dirs[i] < dirs[j]
+	})
+	for _, dir := range dirs {
+		hookDir := hooks.dirs[dir]
+		hookDir.tornDown = true
+		if hookDir.teardown != "" {
+			r.runHook(hooks, teardownHook, hookDir.teardown, testFolder)
+		}
+	}
+}
+
+// runHook runs a setup or teardown hook, and reports its progress and
+// result.  Teardown hooks are not stopped when the run is interrupted, only
+// when it is killed.
+func (r *Runner) runHook(hooks *hookSet, hook string, script string, testFolder string) HookResult {
+	r.hookStart(hook, script)
+	interrupted := r.interrupted
+	if hook == teardownHook {
+		interrupted = nil
+	}
+	testResult, exitCode := r.runAttempt(script, testFolder, interrupted)
+	result := HookResult{TestResult: testResult, Hook: hook, ExitCode: exitCode}
+	r.hookEnd(result)
+
+	hooks.mutex.Lock()
+	hooks.results = append(hooks.results, result)
+	hooks.mutex.Unlock()
+	return result
+}
+
+// skipTest reports the i-th test as skipped without running it.
+func (r *Runner) skipTest(i int, testFile string, skipReason string) testRun {
+	now := time.Now()
+	result := TestResult{
+		TestFile:   testFile,
+		StartTime:  now,
+		EndTime:    now,
+		Metadata:   r.testMetadata(testFile),
+		SkipReason: skipReason,
+	}
+	r.testEnd(i, result, skipTestExitCode)
+	return testRun{result: result, exitCode: skipTestExitCode}
+}
+
+// HookResult contains the result of a setup or teardown hook.
+type HookResult struct {
+	TestResult
+	Hook     string `json:"hook"`
+	ExitCode int    `json:"exitcode"`
+}
+
+// failed tells whether the hook failed, rather than being interrupted.
+func (result HookResult) failed() bool {
+	return result.ExitCode != 0 && result.ExitCode != interruptedExitCode
+}
+
+// status describes the outcome of the hook.
+func (result HookResult) status() string {
+	if result.ExitCode == 0 {
+		return "passed"
+	} else if result.ExitCode == interruptedExitCode {
+		return "interrupted"
+	}
+	return fmt.Sprintf("failed with exit code %d", result.ExitCode)
+}
+
+func (result HookResult) String() string {
+	label := strings.ToUpper(result.Hook)
+	if result.ExitCode == 0 {
+		label = greenBold(label + " PASSED")
+	} else if result.ExitCode == interruptedExitCode {
+		label = yellowBold(label + " INTERRUPTED")
+	} else {
+		label = redBold(label + " FAILED")
+	}
+	return fmt.Sprintf("%s: %s (%v)\n", label, result.TestFile, formatDuration(result.Duration))
+}
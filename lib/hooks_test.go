@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParentDirs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		testFile string
+		expected []string
+	}{
+		{testFile: "a_test.sh", expected: []string{"."}},
+		{testFile: "cf/a_test.sh", expected: []string{".", "cf"}},
+		{testFile: "cf/apps/a_test.sh", expected: []string{".", "cf", filepath.Join("cf", "apps")}},
+	}
+	for _, tt := range tests {
+		if dirs := parentDirs(tt.testFile); !reflect.DeepEqual(dirs, tt.expected) {
+			t.Errorf("Expected parent directories of %s to be %v, have %v", tt.testFile, tt.expected, dirs)
+		}
+	}
+}
+
+func setupHooksRunner(stdout *concurrentBuffer) *Runner {
+	testFolder, _ := filepath.Abs("../testdata/hooks")
+	r := setupDefaultRunner(stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+	r.options.SetupScript = "setup.sh"
+	r.options.TeardownScript = "teardown.sh"
+	return r
+}
+
+func TestRunCommandHooks(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	r := setupHooksRunner(&stdout)
+	err := r.RunCommand()
+	if err == nil || err.Error() != "1 hooks failed" {
+		t.Errorf("Expected error %q, have %v", "1 hooks failed", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 3 test files\n" +
+		"Running setup hook setup.sh\n" +
+		"SETUP PASSED: setup.sh (DURATION)\n\n" +
+		"Running setup hook broken/setup.sh\n" +
+		"SETUP FAILED: broken/setup.sh (DURATION)\n\n" +
+		"Hook output:\n" +
+		"Cannot set up broken\n" +
+		"SKIPPED: broken/broken_test.sh (DURATION): setup broken/setup.sh failed with exit code 3\n\n" +
+		"Running teardown hook broken/teardown.sh\n" +
+		"TEARDOWN PASSED: broken/teardown.sh (DURATION)\n\n" +
+		"Running setup hook ok/setup.sh\n" +
+		"SETUP PASSED: ok/setup.sh (DURATION)\n\n" +
+		"Running test ok/ok_test.sh (2/3)\n" +
+		"PASSED: ok/ok_test.sh (DURATION)\n\n" +
+		"Running teardown hook ok/teardown.sh\n" +
+		"TEARDOWN PASSED: ok/teardown.sh (DURATION)\n\n" +
+		"Running test root_test.sh (3/3)\n" +
+		"PASSED: root_test.sh (DURATION)\n\n" +
+		"Running teardown hook teardown.sh\n" +
+		"TEARDOWN PASSED: teardown.sh (DURATION)\n\n" +
+		"Tests complete: 2 Passed, 1 Skipped, 0 Failed\n\n" +
+		"  Hooks:\n" +
+		"    setup setup.sh passed (DURATION)\n" +
+		"    setup broken/setup.sh failed with exit code 3 (DURATION)\n" +
+		"    teardown broken/teardown.sh passed (DURATION)\n" +
+		"    setup ok/setup.sh passed (DURATION)\n" +
+		"    teardown ok/teardown.sh passed (DURATION)\n" +
+		"    teardown teardown.sh passed (DURATION)\n\n" +
+		"  Skipped tests:\n" +
+		"    broken/broken_test.sh (DURATION): setup broken/setup.sh failed with exit code 3\n\n"
+	if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestRunCommandHooksJSON(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	r := setupHooksRunner(&stdout)
+	r.options.JSONOutput = true
+	r.options.Parallelism = 3
+	if err := r.RunCommand(); err == nil {
+		t.Errorf("Expected the failed hook to fail the run")
+	}
+
+	var results jsonResults
+	if err := json.NewDecoder(&stdout).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.SkippedList) != 1 || results.SkippedList[0].SkipReason != "setup broken/setup.sh failed with exit code 3" {
+		t.Errorf("Expected broken/broken_test.sh to be skipped because of its setup, have %+v", results.SkippedList)
+	}
+	if results.Passed != 2 {
+		t.Errorf("Expected 2 passed tests, have %d", results.Passed)
+	}
+
+	// With tests running concurrently, only the order of the hooks of each
+	// directory is known.
+	hookIndex := make(map[string]int)
+	for i, result := range results.Hooks {
+		hookIndex[result.TestFile] = i
+		expectedExitCode := 0
+		if result.TestFile == "broken/setup.sh" {
+			expectedExitCode = 3
+		}
+		if result.ExitCode != expectedExitCode {
+			t.Errorf("Expected hook %s to exit with %d, have %d", result.TestFile, expectedExitCode, result.ExitCode)
+		}
+	}
+	if len(hookIndex) != 6 {
+		t.Fatalf("Expected 6 hooks to run, have %v", hookIndex)
+	}
+	for _, order := range [][2]string{
+		{"setup.sh", "broken/setup.sh"},
+		{"setup.sh", "ok/setup.sh"},
+		{"broken/setup.sh", "broken/teardown.sh"},
+		{"ok/setup.sh", "ok/teardown.sh"},
+		{"broken/teardown.sh", "teardown.sh"},
+		{"ok/teardown.sh", "teardown.sh"},
+	} {
+		if hookIndex[order[0]] >= hookIndex[order[1]] {
+			t.Errorf("Expected %s to run before %s", order[0], order[1])
+		}
+	}
+}
+
+func TestRunCommandDryRunHooks(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/hooks")
+	var stdout concurrentBuffer
+	r := setupHooksRunner(&stdout)
+	r.options.DryRun = true
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 3 test files\n" +
+		fmt.Sprintf("Test root: %s\n", testFolder) +
+		"Test files:\n" +
+		"\tbroken/broken_test.sh\n" +
+		"\tok/ok_test.sh\n" +
+		"\troot_test.sh\n" +
+		"Hooks:\n" +
+		"\tsetup.sh\n" +
+		"\tteardown.sh\n" +
+		"\tbroken/setup.sh\n" +
+		"\tbroken/teardown.sh\n" +
+		"\tok/setup.sh\n" +
+		"\tok/teardown.sh\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
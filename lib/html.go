@@ -0,0 +1,428 @@
+package lib
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// htmlReporter writes a self-contained HTML report of a run once it is done.
+type htmlReporter struct {
+	runner    *Runner
+	run       RunInfo
+	startTime time.Time
+}
+
+func newHTMLReporter(r *Runner) *htmlReporter {
+	return &htmlReporter{runner: r}
+}
+
+func (reporter *htmlReporter) RunStart(run RunInfo) {
+	reporter.run = run
+	reporter.startTime = time.Now()
+}
+
+func (reporter *htmlReporter) TestStart(int, string)     {}
+func (reporter *htmlReporter) TestOutput(string, []byte) {}
+func (reporter *htmlReporter) TestEnd(FinishedTest)      {}
+func (reporter *htmlReporter) RunEnd(results Results) error {
+	if err := reporter.writeHTMLReport(results); err != nil {
+		return fmt.Errorf("Error writing HTML report: %s", err)
+	}
+	return nil
+}
+
+// htmlReport is what the HTML report shows.
+type htmlReport struct {
+	Summary     jsonResults
+	Environment []htmlProperty
+	Tests       []htmlTest
+	Hooks       []htmlTest
+}
+
+type htmlProperty struct {
+	Name  string
+	Value string
+}
+
+// htmlTest is a row of the table of tests, or of hooks.
+type htmlTest struct {
+	// Order is the position of the test in the run, counting from 1.
+	Order    int
+	Name     string
+	Status   string
+	Duration time.Duration
+	// Details tells why the test failed or was skipped.
+	Details  string
+	Output   string
+	Attempts []htmlAttempt
+	// Reports are the sub-tests, values, steps and warnings of the test.
+	Reports []string
+	// Links are the logs and the artifacts of the test in the results
+	// directory.
+	Links []htmlLink
+}
+
+// htmlAttempt is a failed attempt of a test that was retried.
+type htmlAttempt struct {
+	Number  int
+	Details string
+	Output  string
+	Links   []htmlLink
+}
+
+// htmlLink is a file of a test in the results directory.
+type htmlLink struct {
+	Name string
+	// Path is the path of the file, relative to the report when possible.
+	Path string
+}
+
+// Rank is the position of the status of the test when the table is sorted by
+// status, which puts the tests needing attention first.
+func (test htmlTest) Rank() int {
+	switch test.Status {
+	case StatusFailed:
+		return 0
+	case StatusNotRun:
+		return 1
+	case StatusFlaky:
+		return 2
+	case StatusSkipped:
+		return 3
+	}
+	return 4
+}
+
+// writeHTMLReport writes the results as an HTML report to the file given in the
+// options.
+func (reporter *htmlReporter) writeHTMLReport(results Results) error {
+	file, err := os.Create(reporter.runner.options.HTMLFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := htmlTemplate.Execute(file, reporter.htmlReport(results)); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (reporter *htmlReporter) htmlReport(results Results) htmlReport {
+	summary := reporter.runner.jsonResults(results)
+	report := htmlReport{
+		Summary:     summary,
+		Environment: reporter.environment(),
+	}
+
+	for _, result := range summary.PassedList {
+		report.Tests = append(report.Tests, newHTMLTest(TestResult(result), StatusPassed, ""))
+	}
+	for _, result := range summary.FlakyList {
+		details := fmt.Sprintf("passed on attempt %d", len(result.FailedAttempts)+1)
+		report.Tests = append(report.Tests, newHTMLTest(TestResult(result), StatusFlaky, details))
+	}
+	for _, result := range summary.SkippedList {
+		report.Tests = append(report.Tests, newHTMLTest(TestResult(result), StatusSkipped, result.SkipReason))
+	}
+	for _, result := range summary.FailedList {
+		report.Tests = append(report.Tests, newHTMLTest(result.TestResult, StatusFailed, result.reason()))
+	}
+	for _, result := range summary.NotRunList {
+		report.Tests = append(report.Tests, newHTMLTest(TestResult(result), StatusNotRun, "the run was interrupted"))
+	}
+
+	// The tests are shown in the order they ran, until the table is sorted.
+	order := make(map[string]int)
+	for i, testFile := range reporter.run.Tests {
+		order[testFile] = i + 1
+	}
+	for i := range report.Tests {
+		if testOrder, found := order[report.Tests[i].Name]; found {
+			report.Tests[i].Order = testOrder
+		} else {
+			report.Tests[i].Order = len(reporter.run.Tests) + i + 1
+		}
+	}
+	sort.Slice(report.Tests, func(i, j int) bool {
+		return report.Tests[i].Order < report.Tests[j].Order
+	})
+	for _, test := range report.Tests {
+		reporter.relativeLinks(test.Links)
+		for _, attempt := range test.Attempts {
+			reporter.relativeLinks(attempt.Links)
+		}
+	}
+
+	for i, result := range summary.Hooks {
+		status := StatusPassed
+		if result.ExitCode == interruptedExitCode {
+			status = StatusNotRun
+		} else if result.ExitCode != 0 {
+			status = StatusFailed
+		}
+		hook := newHTMLTest(result.TestResult, status, "")
+		hook.Order = i + 1
+		hook.Name = fmt.Sprintf("%s %s", result.Hook, result.TestFile)
+		if status != StatusPassed {
+			hook.Details = result.status()
+		}
+		reporter.relativeLinks(hook.Links)
+		report.Hooks = append(report.Hooks, hook)
+	}
+	return report
+}
+
+// relativeLinks makes the paths of links relative to the report, so that they
+// still work when the report and the results directory are moved together.
+func (reporter *htmlReporter) relativeLinks(links []htmlLink) {
+	reportDir, err := filepath.Abs(filepath.Dir(reporter.runner.options.HTMLFile))
+	if err != nil {
+		return
+	}
+	for i := range links {
+		if path, err := filepath.Abs(links[i].Path); err == nil {
+			if relPath, err := filepath.Rel(reportDir, path); err == nil {
+				links[i].Path = relPath
+			}
+		}
+		links[i].Path = filepath.ToSlash(links[i].Path)
+	}
+}
+
+// environment describes where and how the tests ran.
+func (reporter *htmlReporter) environment() []htmlProperty {
+	r := reporter.runner
+	properties := []htmlProperty{
+		{Name: "Command", Value: strings.Join(os.Args, " ")},
+		{Name: "Test root", Value: reporter.run.TestRoot},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		properties = append(properties, htmlProperty{Name: "Host", Value: hostname})
+	}
+	properties = append(properties,
+		htmlProperty{Name: "Platform", Value: fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)},
+		htmlProperty{Name: "Started", Value: reporter.startTime.Format(time.RFC1123)},
+		htmlProperty{Name: "Duration", Value: formatDuration(time.Since(reporter.startTime)).String()},
+	)
+	if r.options.TagsExpr != "" {
+		properties = append(properties, htmlProperty{Name: "Tags", Value: r.options.TagsExpr})
+	}
+	if r.options.Parallelism > 1 {
+		properties = append(properties, htmlProperty{Name: "Jobs", Value: fmt.Sprintf("%d", r.options.Parallelism)})
+	}
+	if r.options.Retries > 0 {
+		properties = append(properties, htmlProperty{Name: "Retries", Value: fmt.Sprintf("%d", r.options.Retries)})
+	}
+	return properties
+}
+
+func newHTMLTest(result TestResult, status string, details string) htmlTest {
+	test := htmlTest{
+		Name:     result.TestFile,
+		Status:   status,
+		Duration: formatDuration(result.Duration),
+		Details:  details,
+		Output:   result.Output,
+	}
+	for i, attempt := range result.FailedAttempts {
+		test.Attempts = append(test.Attempts, htmlAttempt{
+			Number:  i + 1,
+			Details: attempt.reason(),
+			Output:  attempt.Output,
+			Links:   newHTMLLinks(attempt.TestResult),
+		})
+	}
+	test.Links = newHTMLLinks(result)
+	for _, subTest := range result.SubTests {
+		line := fmt.Sprintf("sub-test %d - %s: %s", subTest.Number, subTest.Description, subTest.Status)
+		if subTest.Reason != "" {
+			line += ", " + subTest.Reason
+		}
+		test.Reports = append(test.Reports, line)
+	}
+	var keys []string
+	for key := range result.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		test.Reports = append(test.Reports, fmt.Sprintf("%s: %s", key, result.Values[key]))
+	}
+	for _, step := range result.Steps {
+		test.Reports = append(test.Reports, fmt.Sprintf("step %s: %s", step.Name, step.Status))
+	}
+	for _, warning := range result.Warnings {
+		test.Reports = append(test.Reports, fmt.Sprintf("warning: %s", warning))
+	}
+	return test
+}
+
+// newHTMLLinks returns the links to the logs and the artifacts of a test, if
+// they were written to the results directory.
+func newHTMLLinks(result TestResult) []htmlLink {
+	if result.ResultsDir == "" {
+		return nil
+	}
+	var links []htmlLink
+	for _, name := range []string{outputLogName, stdoutLogName, stderrLogName} {
+		links = append(links, htmlLink{Name: name, Path: filepath.Join(result.ResultsDir, name)})
+	}
+	for i, path := range result.artifactPaths() {
+		links = append(links, htmlLink{Name: result.Artifacts[i], Path: path})
+	}
+	return links
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>testbrain report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0.2em; }
+.counts span { display: inline-block; margin: 0.5em 1em 0.5em 0; padding: 0.4em 0.8em; border-radius: 4px; color: #fff; font-weight: bold; }
+.passed { background: #2e7d32; }
+.flaky { background: #ef6c00; }
+.skipped { background: #757575; }
+.failed { background: #c62828; }
+.notRun { background: #6a1b9a; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+th[data-sort] { cursor: pointer; user-select: none; }
+th[data-sort]:after { content: " \2195"; color: #aaa; }
+table.environment { width: auto; }
+table.environment th { font-weight: normal; color: #555; }
+tr.test { cursor: pointer; }
+tr.test:hover { background: #f5f5f5; }
+td.status span { padding: 0.1em 0.5em; border-radius: 3px; color: #fff; }
+tr.output > td { background: #fafafa; }
+pre { white-space: pre-wrap; word-break: break-all; margin: 0.3em 0; font-size: 0.9em; }
+.filters { margin: 1em 0; }
+.filters label { margin-right: 1em; }
+</style>
+</head>
+<body>
+<h1>testbrain report</h1>
+<div class="counts">
+<span class="passed">{{.Summary.Passed}} Passed</span>
+{{- if .Summary.Flaky}}<span class="flaky">{{.Summary.Flaky}} Flaky</span>{{end}}
+<span class="skipped">{{.Summary.Skipped}} Skipped</span>
+<span class="failed">{{.Summary.Failed}} Failed</span>
+{{- if .Summary.NotRun}}<span class="notRun">{{.Summary.NotRun}} Not run</span>{{end}}
+</div>
+{{if .Summary.Interrupted}}<p><strong>The run was interrupted.</strong></p>{{end}}
+<table class="environment">
+<tr><th>Seed</th><td>{{if .Summary.InOrder}}in order{{else}}{{.Summary.Seed}}{{end}}</td></tr>
+{{- with .Summary.Shard}}
+<tr><th>Shard</th><td>{{.Index}} of {{.Total}}</td></tr>
+{{- end}}
+{{- range .Environment}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Tests</h2>
+<div class="filters">
+<input type="search" id="filter" placeholder="Filter tests" oninput="filterTests()">
+<label><input type="checkbox" class="status" value="passed" checked onchange="filterTests()"> passed</label>
+<label><input type="checkbox" class="status" value="flaky" checked onchange="filterTests()"> flaky</label>
+<label><input type="checkbox" class="status" value="skipped" checked onchange="filterTests()"> skipped</label>
+<label><input type="checkbox" class="status" value="failed" checked onchange="filterTests()"> failed</label>
+<label><input type="checkbox" class="status" value="notRun" checked onchange="filterTests()"> not run</label>
+</div>
+<table id="tests">
+<thead>
+<tr><th data-sort="order">#</th><th data-sort="name">Test</th><th data-sort="rank">Status</th><th data-sort="duration">Duration</th><th>Details</th></tr>
+</thead>
+{{- range .Tests}}
+{{template "test" .}}
+{{- end}}
+</table>
+{{- if .Hooks}}
+
+<h2>Hooks</h2>
+<table id="hooks">
+<thead>
+<tr><th>#</th><th>Hook</th><th>Status</th><th>Duration</th><th>Details</th></tr>
+</thead>
+{{- range .Hooks}}
+{{template "test" .}}
+{{- end}}
+</table>
+{{- end}}
+
+<script>
+function filterTests() {
+  var text = document.getElementById("filter").value.toLowerCase();
+  var statuses = {};
+  document.querySelectorAll("input.status").forEach(function(input) {
+    statuses[input.value] = input.checked;
+  });
+  document.querySelectorAll("#tests tbody").forEach(function(test) {
+    var shown = statuses[test.dataset.status] && test.dataset.name.toLowerCase().indexOf(text) >= 0;
+    test.style.display = shown ? "" : "none";
+  });
+}
+
+document.querySelectorAll("tr.test").forEach(function(row) {
+  row.addEventListener("click", function() {
+    var output = row.nextElementSibling;
+    output.hidden = !output.hidden;
+  });
+});
+
+document.querySelectorAll("#tests th[data-sort]").forEach(function(header) {
+  var ascending = true;
+  header.addEventListener("click", function() {
+    var key = header.dataset.sort;
+    var table = document.getElementById("tests");
+    var tests = Array.prototype.slice.call(table.querySelectorAll("tbody"));
+    tests.sort(function(a, b) {
+      var x = a.dataset[key], y = b.dataset[key];
+      var order = key === "name" ? x.localeCompare(y) : Number(x) - Number(y);
+      return ascending ? order : -order;
+    });
+    tests.forEach(function(test) { table.appendChild(test); });
+    ascending = !ascending;
+  });
+});
+</script>
+</body>
+</html>
+{{define "test"}}<tbody data-order="{{.Order}}" data-name="{{.Name}}" data-status="{{.Status}}" data-rank="{{.Rank}}" data-duration="{{.Duration.Nanoseconds}}">
+<tr class="test"><td>{{.Order}}</td><td>{{.Name}}</td><td class="status"><span class="{{.Status}}">{{.Status}}</span></td><td>{{.Duration}}</td><td>{{.Details}}</td></tr>
+<tr class="output" hidden><td colspan="5">
+{{- range .Attempts}}
+<p>Attempt {{.Number}} failed with {{.Details}}:</p>
+{{- template "links" .Links}}
+<pre>{{.Output}}</pre>
+{{- end}}
+{{- if .Reports}}
+<ul>
+{{- range .Reports}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Attempts}}
+<p>Last attempt:</p>
+{{- end}}
+{{- template "links" .Links}}
+{{- if .Output}}
+<pre>{{.Output}}</pre>
+{{- else}}
+<p>No output.</p>
+{{- end}}
+</td></tr>
+</tbody>{{end}}{{define "links"}}{{if .}}
+<p class="links">
+{{- range $i, $link := .}}{{if $i}} | {{end}}<a href="{{$link.Path}}">{{$link.Name}}</a>{{end -}}
+</p>{{end}}{{end}}`))
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommandHTMLReport(t *testing.T) {
+	t.Parallel()
+
+	tempDir, err := ioutil.TempDir("", "testbrain-html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.RandomSeed = 42
+	r.options.HTMLFile = filepath.Join(tempDir, "report.html")
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	reportBytes, err := ioutil.ReadFile(r.options.HTMLFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(reportBytes)
+	expectedParts := []string{
+		`<span class="passed">1 Passed</span>`,
+		`<span class="skipped">1 Skipped</span>`,
+		`<span class="failed">1 Failed</span>`,
+		"<tr><th>Seed</th><td>42</td></tr>",
+		"<tr><th>Test root</th><td>" + testFolder + "</td></tr>",
+		`data-name="fail_test.sh" data-status="failed" data-rank="0"`,
+		`<td>fail_test.sh</td><td class="status"><span class="failed">failed</span></td>`,
+		"<td>exit code 42</td>",
+		"<pre>Goodbye World!\n</pre>",
+		`data-name="skip_test.sh" data-status="skipped" data-rank="3"`,
+		"<td>Nothing to test here</td>",
+		`data-name="success_test.sh" data-status="passed" data-rank="4"`,
+		"<pre>Hello World!\n</pre>",
+	}
+	for _, part := range expectedParts {
+		if !strings.Contains(report, part) {
+			t.Errorf("Expected the HTML report to contain %q, have:\n%s", part, report)
+		}
+	}
+}
+
+func TestHTMLReportEscaping(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.InOrder = true
+	reporter := newHTMLReporter(r)
+	reporter.RunStart(RunInfo{Tests: []string{"testfile-flaky", "<b>testfile</b>"}})
+	failedAttempt := FailedResult{
+		TestResult: TestResult{TestFile: "testfile-flaky", Output: "Try again\n"},
+		ExitCode:   3,
+	}
+	results := Results{
+		Passed: []PassedResult{{
+			TestFile: "<b>testfile</b>",
+			Output:   "<script>alert('Hello')</script>\n",
+			Values:   map[string]string{"region": "eu-west"},
+		}},
+		Flaky: []FlakyResult{{
+			TestFile:       "testfile-flaky",
+			Duration:       1500 * time.Millisecond,
+			FailedAttempts: []FailedResult{failedAttempt},
+		}},
+	}
+
+	var report bytes.Buffer
+	if err := htmlTemplate.Execute(&report, reporter.htmlReport(results)); err != nil {
+		t.Fatalf("Error writing HTML report: %s", err)
+	}
+	expectedParts := []string{
+		"<tr><th>Seed</th><td>in order</td></tr>",
+		`<tbody data-order="1" data-name="testfile-flaky" data-status="flaky" data-rank="2" data-duration="1500000000">`,
+		"<td>passed on attempt 2</td>",
+		"<p>Attempt 1 failed with exit code 3:</p>\n<pre>Try again\n</pre>",
+		`<tbody data-order="2" data-name="&lt;b&gt;testfile&lt;/b&gt;"`,
+		"<pre>&lt;script&gt;alert(&#39;Hello&#39;)&lt;/script&gt;\n</pre>",
+		"<li>region: eu-west</li>",
+	}
+	for _, part := range expectedParts {
+		if !strings.Contains(report.String(), part) {
+			t.Errorf("Expected the HTML report to contain %q, have:\n%s", part, report.String())
+		}
+	}
+}
+
+func TestHTMLReportLinks(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.HTMLFile = filepath.Join("reports", "report.html")
+	reporter := newHTMLReporter(r)
+	reporter.RunStart(RunInfo{Tests: []string{"a_test.sh"}})
+	results := Results{
+		Passed: []PassedResult{{
+			TestFile:   "a_test.sh",
+			ResultsDir: filepath.Join("reports", "results", "a_test.sh"),
+			Artifacts:  []string{"artifacts/screenshot.png"},
+		}},
+	}
+
+	var report bytes.Buffer
+	if err := htmlTemplate.Execute(&report, reporter.htmlReport(results)); err != nil {
+		t.Fatalf("Error writing HTML report: %s", err)
+	}
+	expected := `<p class="links"><a href="results/a_test.sh/output.log">output.log</a>` +
+		` | <a href="results/a_test.sh/stdout.log">stdout.log</a>` +
+		` | <a href="results/a_test.sh/stderr.log">stderr.log</a>` +
+		` | <a href="results/a_test.sh/artifacts/screenshot.png">artifacts/screenshot.png</a></p>`
+	if !strings.Contains(report.String(), expected) {
+		t.Errorf("Expected the HTML report to contain %q, have:\n%s", expected, report.String())
+	}
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// ErrInterrupted is returned by RunCommand when the run was interrupted
+// before all the tests were run.
+var ErrInterrupted = errors.New("Test run interrupted")
+
+// Interrupt stops the run: the tests currently running are terminated the
+// same way as when they time out, and no further tests are started.
+// RunCommand then outputs the results of the tests that completed and
+// returns ErrInterrupted.  It is safe to call from any goroutine, as many
+// times as needed.
+func (r *Runner) Interrupt() {
+	r.interruptOnce.Do(func() {
+		close(r.interrupted)
+	})
+}
+
+// Kill immediately sends SIGKILL to every test that is currently running,
+// along with all the processes they spawned.  It is meant to be called before
+// exiting when the run cannot be stopped gracefully.
+func (r *Runner) Kill() {
+	r.Interrupt()
+
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	for command := range r.running {
+		signalProcessGroup(command, syscall.SIGKILL)
+	}
+}
+
+// isInterrupted tells whether Interrupt was called.
+func (r *Runner) isInterrupted() bool {
+	select {
+	case <-r.interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// addRunning keeps track of a test that started, so Kill can get to it.
+func (r *Runner) addRunning(command *exec.Cmd) {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	r.running[command] = struct{}{}
+}
+
+func (r *Runner) removeRunning(command *exec.Cmd) {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	delete(r.running, command)
+}
@@ -0,0 +1,186 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Actions of the events in the JSON stream output.
+const (
+	streamActionRunStart  = "runStart"
+	streamActionTestStart = "testStart"
+	streamActionOutput    = "output"
+	streamActionRetry     = "retry"
+	streamActionTestEnd   = "testEnd"
+	streamActionHookStart = "hookStart"
+	streamActionHookEnd   = "hookEnd"
+	streamActionRunEnd    = "runEnd"
+)
+
+// streamEvent is a single event of the JSON stream output.  Only the fields
+// relevant to the action are set.
+type streamEvent struct {
+	Time     time.Time    `json:"time"`
+	Action   string       `json:"action"`
+	Test     string       `json:"test,omitempty"`
+	Hook     string       `json:"hook,omitempty"`
+	Run      *streamRun   `json:"run,omitempty"`
+	Output   string       `json:"output,omitempty"`
+	Status   string       `json:"status,omitempty"`
+	ExitCode *int         `json:"exitcode,omitempty"`
+	Result   interface{}  `json:"result,omitempty"`
+	Summary  *jsonResults `json:"summary,omitempty"`
+}
+
+// streamRun describes the run in the runStart event.
+type streamRun struct {
+	Seed     int64      `json:"seed"`
+	InOrder  bool       `json:"inOrder"`
+	Shard    *ShardInfo `json:"shard,omitempty"`
+	TestRoot string     `json:"testRoot"`
+	Tests    []string   `json:"tests"`
+}
+
+// jsonStream writes the progress of a run as JSON events, one per line, as
+// soon as they happen.  It is safe for use by several goroutines.
+type jsonStream struct {
+	runner  *Runner
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	// started holds the tests that were started, as the tests skipped or
+	// failed without being run still get a testStart event.
+	started map[string]bool
+}
+
+func newJSONStream(r *Runner) *jsonStream {
+	return &jsonStream{
+		runner:  r,
+		encoder: json.NewEncoder(r.stdout),
+		started: make(map[string]bool),
+	}
+}
+
+func (s *jsonStream) emit(event streamEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	event.Time = time.Now()
+	if err := s.encoder.Encode(event); err != nil {
+		fmt.Fprintln(s.runner.stderr, redBold("Error trying to marshal JSON event"))
+	}
+}
+
+func (s *jsonStream) RunStart(run RunInfo) {
+	s.emit(streamEvent{
+		Action: streamActionRunStart,
+		Run: &streamRun{
+			Seed:     run.Seed,
+			InOrder:  run.InOrder,
+			Shard:    run.Shard,
+			TestRoot: run.TestRoot,
+			Tests:    run.Tests,
+		},
+	})
+}
+
+func (s *jsonStream) TestStart(index int, testFile string) {
+	s.mutex.Lock()
+	s.started[testFile] = true
+	s.mutex.Unlock()
+	s.emit(streamEvent{
+		Action: streamActionTestStart,
+		Test:   testFile,
+	})
+}
+
+// TestOutput turns the output of a test into an output event.
+func (s *jsonStream) TestOutput(testFile string, output []byte) {
+	s.emit(streamEvent{
+		Action: streamActionOutput,
+		Test:   testFile,
+		Output: string(output),
+	})
+}
+
+func (s *jsonStream) TestRetry(index int, attempt FailedResult) {
+	exitCode := attempt.ExitCode
+	s.emit(streamEvent{
+		Action:   streamActionRetry,
+		Test:     attempt.TestFile,
+		ExitCode: &exitCode,
+		Result:   attempt,
+	})
+}
+
+func (s *jsonStream) TestEnd(test FinishedTest) {
+	s.mutex.Lock()
+	started := s.started[test.Result.TestFile]
+	delete(s.started, test.Result.TestFile)
+	s.mutex.Unlock()
+	if !started {
+		s.emit(streamEvent{
+			Action: streamActionTestStart,
+			Test:   test.Result.TestFile,
+		})
+	}
+
+	exitCode := test.ExitCode
+	event := streamEvent{
+		Action:   streamActionTestEnd,
+		Test:     test.Result.TestFile,
+		Status:   test.Status,
+		ExitCode: &exitCode,
+	}
+	switch test.Status {
+	case StatusNotRun:
+		event.Result = NotRunResult(test.Result)
+		event.ExitCode = nil
+	case StatusSkipped:
+		event.Result = SkippedResult(test.Result)
+	case StatusFlaky:
+		event.Result = FlakyResult(test.Result)
+	case StatusPassed:
+		event.Result = PassedResult(test.Result)
+	default:
+		event.Result = FailedResult{TestResult: test.Result, ExitCode: test.ExitCode}
+	}
+	s.emit(event)
+}
+
+func (s *jsonStream) HookStart(hook string, script string) {
+	s.emit(streamEvent{
+		Action: streamActionHookStart,
+		Test:   script,
+		Hook:   hook,
+	})
+}
+
+func (s *jsonStream) HookEnd(result HookResult) {
+	exitCode := result.ExitCode
+	event := streamEvent{
+		Action:   streamActionHookEnd,
+		Test:     result.TestFile,
+		Hook:     result.Hook,
+		ExitCode: &exitCode,
+		Result:   result,
+	}
+	if exitCode == interruptedExitCode {
+		event.Status = StatusNotRun
+		event.ExitCode = nil
+	} else if exitCode == 0 {
+		event.Status = StatusPassed
+	} else {
+		event.Status = StatusFailed
+	}
+	s.emit(event)
+}
+
+func (s *jsonStream) RunEnd(results Results) error {
+	summary := s.runner.jsonResults(results)
+	s.emit(streamEvent{
+		Action:  streamActionRunEnd,
+		Summary: &summary,
+	})
+	return nil
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunCommandJSONStream(t *testing.T) {
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.JSONStreamOutput = true
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err == nil {
+		t.Errorf("Expected an error, got nothing")
+	}
+
+	type event struct {
+		Action   string `json:"action"`
+		Test     string `json:"test"`
+		Output   string `json:"output"`
+		Status   string `json:"status"`
+		ExitCode *int   `json:"exitcode"`
+		Run      *struct {
+			Seed  int64    `json:"seed"`
+			Tests []string `json:"tests"`
+		} `json:"run"`
+		Summary *struct {
+			Passed  int `json:"passed"`
+			Skipped int `json:"skipped"`
+			Failed  int `json:"failed"`
+		} `json:"summary"`
+	}
+	var events []event
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Error parsing event %q: %s", scanner.Text(), err)
+		}
+		// Output may come in any number of chunks; only keep track of it as a whole.
+		if len(events) > 0 && e.Action == streamActionOutput && events[len(events)-1].Action == streamActionOutput {
+			events[len(events)-1].Output += e.Output
+			continue
+		}
+		events = append(events, e)
+	}
+
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action+" "+e.Test)
+	}
+	expectedActions := []string{
+		"runStart ",
+		"testStart fail_test.sh",
+		"output fail_test.sh",
+		"testEnd fail_test.sh",
+		"testStart skip_test.sh",
+		"output skip_test.sh",
+		"testEnd skip_test.sh",
+		"testStart success_test.sh",
+		"output success_test.sh",
+		"testEnd success_test.sh",
+		"runEnd ",
+	}
+	if !reflect.DeepEqual(actions, expectedActions) {
+		t.Fatalf("\nExpected events:\n%v\nHave:\n%v\n", expectedActions, actions)
+	}
+
+	runStart := events[0]
+	expectedTests := []string{"fail_test.sh", "skip_test.sh", "success_test.sh"}
+	if runStart.Run == nil || runStart.Run.Seed != -1 || !reflect.DeepEqual(runStart.Run.Tests, expectedTests) {
+		t.Errorf("Unexpected runStart event: %+v", runStart.Run)
+	}
+	if output := events[2].Output; output != "Goodbye World!\n" {
+		t.Errorf("\nExpected output:\n%q\nHave:\n%q\n", "Goodbye World!\n", output)
+	}
+	for _, tt := range []struct {
+		event    event
+		status   string
+		exitCode int
+	}{
+		{events[3], StatusFailed, 42},
+		{events[6], StatusSkipped, skipTestExitCode},
+		{events[9], StatusPassed, 0},
+	} {
+		if tt.event.Status != tt.status || tt.event.ExitCode == nil || *tt.event.ExitCode != tt.exitCode {
+			t.Errorf("Expected %s to end with status %s and exit code %d, have %+v", tt.event.Test, tt.status, tt.exitCode, tt.event)
+		}
+	}
+	summary := events[len(events)-1].Summary
+	if summary == nil || summary.Passed != 1 || summary.Skipped != 1 || summary.Failed != 1 {
+		t.Errorf("Unexpected runEnd summary: %+v", summary)
+	}
+}
@@ -0,0 +1,259 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// Earlier failed attempts of retried tests are reported the way Maven
+// Surefire does, which Jenkins understands.
+type junitTestCase struct {
+	Name          string              `xml:"name,attr"`
+	ClassName     string              `xml:"classname,attr"`
+	Time          string              `xml:"time,attr"`
+	Failure       *junitFailure       `xml:"failure,omitempty"`
+	FlakyFailures []junitRetryFailure `xml:"flakyFailure"`
+	RerunFailures []junitRetryFailure `xml:"rerunFailure"`
+	Skipped       *junitSkipped       `xml:"skipped,omitempty"`
+	SystemOut     string              `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type junitRetryFailure struct {
+	Message   string `xml:"message,attr"`
+	Type      string `xml:"type,attr"`
+	SystemOut string `xml:"system-out,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// junitReporter writes the JUnit report of a run once it is done.
+type junitReporter struct {
+	runner *Runner
+}
+
+func (reporter junitReporter) RunStart(RunInfo)          {}
+func (reporter junitReporter) TestStart(int, string)     {}
+func (reporter junitReporter) TestOutput(string, []byte) {}
+func (reporter junitReporter) TestEnd(FinishedTest)      {}
+func (reporter junitReporter) RunEnd(results Results) error {
+	if err := reporter.runner.writeJUnitReport(results); err != nil {
+		return fmt.Errorf("Error writing JUnit report: %s", err)
+	}
+	return nil
+}
+
+// writeJUnitReport writes the results as a JUnit XML report to the file given
+// in the options.
+func (r *Runner) writeJUnitReport(results Results) error {
+	file, err := os.Create(r.options.JUnitFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	report := r.junitReport(results)
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if _, err := file.WriteString("\n"); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (r *Runner) junitReport(results Results) junitTestSuites {
+	suite := junitTestSuite{
+		Name: "testbrain",
+	}
+	if !r.options.InOrder {
+		suite.Properties = append(suite.Properties, junitProperty{
+			Name:  "seed",
+			Value: fmt.Sprintf("%d", r.options.RandomSeed),
+		})
+	}
+	if shard := r.shardInfo(); shard != nil {
+		suite.Properties = append(suite.Properties,
+			junitProperty{Name: "shardIndex", Value: fmt.Sprintf("%d", shard.Index)},
+			junitProperty{Name: "shardTotal", Value: fmt.Sprintf("%d", shard.Total)},
+		)
+	}
+
+	var totalDuration time.Duration
+	for _, result := range results.Passed {
+		suite.TestCases = append(suite.TestCases, newJUnitTestCase(TestResult(result)))
+		suite.TestCases = append(suite.TestCases, newJUnitSubTestCases(TestResult(result))...)
+		totalDuration += result.Duration
+	}
+	for _, result := range results.Flaky {
+		testCase := newJUnitTestCase(TestResult(result))
+		testCase.FlakyFailures = newJUnitRetryFailures(result.FailedAttempts)
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.TestCases = append(suite.TestCases, newJUnitSubTestCases(TestResult(result))...)
+		totalDuration += result.Duration
+	}
+	for _, result := range results.Skipped {
+		testCase := newJUnitTestCase(TestResult(result))
+		testCase.Skipped = &junitSkipped{
+			Message: fmt.Sprintf("Skipped with exit code %d", skipTestExitCode),
+		}
+		if result.SkipReason != "" {
+			testCase.Skipped.Message = fmt.Sprintf("Skipped, %s", result.SkipReason)
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.TestCases = append(suite.TestCases, newJUnitSubTestCases(TestResult(result))...)
+		totalDuration += result.Duration
+	}
+	for _, result := range results.Failed {
+		testCase := newJUnitTestCase(result.TestResult)
+		testCase.Failure = &junitFailure{
+			Message: junitFailureMessage(result),
+			Type:    "exitcode",
+			Value:   fmt.Sprintf("%d", result.ExitCode),
+		}
+		testCase.RerunFailures = newJUnitRetryFailures(result.FailedAttempts)
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.TestCases = append(suite.TestCases, newJUnitSubTestCases(result.TestResult)...)
+		totalDuration += result.Duration
+	}
+	for _, result := range results.NotRun {
+		testCase := newJUnitTestCase(TestResult(result))
+		testCase.Skipped = &junitSkipped{
+			Message: "Not run, the test run was interrupted",
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		totalDuration += result.Duration
+	}
+	suite.Time = junitDuration(totalDuration)
+	for _, testCase := range suite.TestCases {
+		suite.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		if testCase.Skipped != nil {
+			suite.Skipped++
+		}
+	}
+
+	return junitTestSuites{Suites: []junitTestSuite{suite}}
+}
+
+func newJUnitRetryFailures(failedAttempts []FailedResult) []junitRetryFailure {
+	var retryFailures []junitRetryFailure
+	for _, attempt := range failedAttempts {
+		retryFailures = append(retryFailures, junitRetryFailure{
+			Message:   junitFailureMessage(attempt),
+			Type:      "exitcode",
+			SystemOut: attempt.Output,
+		})
+	}
+	return retryFailures
+}
+
+func junitFailureMessage(result FailedResult) string {
+	return fmt.Sprintf("Failed with %s", result.reason())
+}
+
+func newJUnitTestCase(result TestResult) junitTestCase {
+	return junitTestCase{
+		Name:      result.TestFile,
+		ClassName: junitClassName(result.TestFile),
+		Time:      junitDuration(result.Duration),
+		SystemOut: result.Output + junitAttachments(result),
+	}
+}
+
+// junitAttachments returns the lines telling the artifacts of a test in its
+// output, which the JUnit Attachments plugin of Jenkins turns into links.
+func junitAttachments(result TestResult) string {
+	var attachments string
+	for _, path := range result.artifactPaths() {
+		if absPath, err := filepath.Abs(path); err == nil {
+			path = absPath
+		}
+		attachments += fmt.Sprintf("[[ATTACHMENT|%s]]\n", path)
+	}
+	return attachments
+}
+
+// junitClassName returns the class name of a test, which is its directory, so
+// that CI tools group tests by directory.
+func junitClassName(testFile string) string {
+	className := strings.Replace(filepath.ToSlash(filepath.Dir(testFile)), "/", ".", -1)
+	if className == "." {
+		className = "testbrain"
+	}
+	return className
+}
+
+// newJUnitSubTestCases returns the test cases of the sub-tests of a test,
+// which are grouped under the class of the test followed by its name.
+func newJUnitSubTestCases(result TestResult) []junitTestCase {
+	className := junitClassName(result.TestFile) + "." + filepath.Base(result.TestFile)
+	var testCases []junitTestCase
+	for _, subTest := range result.SubTests {
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%d - %s", subTest.Number, subTest.Description),
+			ClassName: className,
+			Time:      junitDuration(0),
+			SystemOut: subTest.Diagnostics,
+		}
+		switch subTest.Status {
+		case subTestFailed:
+			testCase.Failure = &junitFailure{
+				Message: "Sub-test failed",
+				Type:    "tap",
+				Value:   subTest.Reason,
+			}
+		case subTestSkipped:
+			testCase.Skipped = &junitSkipped{Message: "Skipped"}
+			if subTest.Reason != "" {
+				testCase.Skipped.Message = fmt.Sprintf("Skipped, %s", subTest.Reason)
+			}
+		}
+		testCases = append(testCases, testCase)
+	}
+	return testCases
+}
+
+func junitDuration(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteJUnitReport(t *testing.T) {
+	t.Parallel()
+
+	tempDir, err := ioutil.TempDir("", "testbrain-junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.RandomSeed = 42
+	r.options.JUnitFile = filepath.Join(tempDir, "report.xml")
+
+	passedResults := []PassedResult{
+		PassedResult{
+			TestFile: "dir/testfile-success",
+			Duration: 1500 * time.Millisecond,
+			Output:   "Hello World!\n",
+		},
+	}
+	skippedResults := []SkippedResult{
+		SkippedResult{
+			TestFile: "testfile-skip",
+		},
+	}
+	failedResults := []FailedResult{
+		FailedResult{
+			TestResult: TestResult{
+				TestFile: "testfile-failure",
+				Duration: 250 * time.Millisecond,
+				Output:   "Goodbye <World>!\n",
+			},
+			ExitCode: 42,
+		},
+	}
+
+	failedAttempt := FailedResult{
+		TestResult: TestResult{
+			TestFile: "testfile-flaky",
+			Output:   "Try again\n",
+		},
+		ExitCode: 3,
+	}
+	flakyResults := []FlakyResult{
+		FlakyResult{
+			TestFile:       "testfile-flaky",
+			Duration:       100 * time.Millisecond,
+			FailedAttempts: []FailedResult{failedAttempt},
+		},
+	}
+	failedResults[0].FailedAttempts = []FailedResult{failedAttempt}
+	results := Results{
+		Passed:  passedResults,
+		Flaky:   flakyResults,
+		Skipped: skippedResults,
+		Failed:  failedResults,
+	}
+
+	err = r.writeJUnitReport(results)
+	if err != nil {
+		t.Fatalf("Error writing JUnit report: %s", err)
+	}
+	reportBytes, err := ioutil.ReadFile(r.options.JUnitFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="testbrain" tests="4" failures="1" errors="0" skipped="1" time="1.850">
+    <properties>
+      <property name="seed" value="42"></property>
+    </properties>
+    <testcase name="dir/testfile-success" classname="dir" time="1.500">
+      <system-out>Hello World!&#xA;</system-out>
+    </testcase>
+    <testcase name="testfile-flaky" classname="testbrain" time="0.100">
+      <flakyFailure message="Failed with exit code 3" type="exitcode">
+        <system-out>Try again&#xA;</system-out>
+      </flakyFailure>
+    </testcase>
+    <testcase name="testfile-skip" classname="testbrain" time="0.000">
+      <skipped message="Skipped with exit code 99"></skipped>
+    </testcase>
+    <testcase name="testfile-failure" classname="testbrain" time="0.250">
+      <failure message="Failed with exit code 42" type="exitcode">42</failure>
+      <rerunFailure message="Failed with exit code 3" type="exitcode">
+        <system-out>Try again&#xA;</system-out>
+      </rerunFailure>
+      <system-out>Goodbye &lt;World&gt;!&#xA;</system-out>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if report := string(reportBytes); report != expected {
+		t.Errorf("\nExpected report:\n%s\n\nHave:\n%s\n", expected, report)
+	}
+}
+
+func TestJUnitAttachments(t *testing.T) {
+	t.Parallel()
+
+	resultsDir, _ := filepath.Abs("results")
+	result := TestResult{
+		TestFile:   "a_test.sh",
+		Output:     "Hello World!\n",
+		ResultsDir: filepath.Join(resultsDir, "a_test.sh"),
+		Artifacts:  []string{"artifacts/screenshot.png"},
+	}
+	expected := "Hello World!\n[[ATTACHMENT|" + filepath.Join(resultsDir, "a_test.sh", "artifacts", "screenshot.png") + "]]\n"
+	if systemOut := newJUnitTestCase(result).SystemOut; systemOut != expected {
+		t.Errorf("Expected the system-out %q, have %q", expected, systemOut)
+	}
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// Formats of the output of ListCommand.
+const (
+	ListFormatText  = "text"
+	ListFormatJSON  = "json"
+	ListFormatPaths = "paths"
+)
+
+// jsonList is the JSON representation of the tests listed.
+type jsonList struct {
+	TestRoot string         `json:"testRoot"`
+	Seed     int64          `json:"seed"`
+	InOrder  bool           `json:"inOrder"`
+	Shard    *ShardInfo     `json:"shard,omitempty"`
+	Tests    []ListedTest   `json:"tests"`
+	Filtered []FilteredTest `json:"filtered"`
+}
+
+// ListedTest is a test that would be run, as listed by ListCommand.
+type ListedTest struct {
+	// Order is the position of the test in the run, starting at 1.
+	Order    int           `json:"order"`
+	TestFile string        `json:"filename"`
+	Path     string        `json:"path"`
+	Metadata *TestMetadata `json:"metadata,omitempty"`
+}
+
+// ListCommand is the entrypoint of the Runner to list tests.  It gathers the
+// test scripts the same way RunCommand does, and displays them in the order
+// they would run, without running them.
+func (r *Runner) ListCommand(format string) error {
+	if format != ListFormatText && format != ListFormatJSON && format != ListFormatPaths {
+		err := fmt.Errorf("Unknown format %q, expected %s, %s or %s", format, ListFormatText, ListFormatJSON, ListFormatPaths)
+		fmt.Fprintln(r.stderr, redBold(err.Error()))
+		return err
+	}
+	testRoot, testFiles, err := r.getTestScriptsWithOrder()
+	if err != nil {
+		fmt.Fprintln(r.stderr, redBold(err.Error()))
+		return err
+	}
+
+	listedTests := make([]ListedTest, 0, len(testFiles))
+	for i, testFile := range testFiles {
+		listedTests = append(listedTests, ListedTest{
+			Order:    i + 1,
+			TestFile: testFile,
+			Path:     filepath.Join(testRoot, testFile),
+			Metadata: r.testMetadata(testFile),
+		})
+	}
+
+	switch format {
+	case ListFormatJSON:
+		filtered := r.filteredTests
+		if filtered == nil {
+			filtered = make([]FilteredTest, 0)
+		}
+		err := json.NewEncoder(r.stdout).Encode(jsonList{
+			TestRoot: testRoot,
+			Seed:     r.displayedSeed(),
+			InOrder:  r.options.InOrder,
+			Shard:    r.shardInfo(),
+			Tests:    listedTests,
+			Filtered: filtered,
+		})
+		if err != nil {
+			fmt.Fprintln(r.stderr, redBold("Error trying to marshal JSON output"))
+			return err
+		}
+	case ListFormatPaths:
+		for _, listedTest := range listedTests {
+			fmt.Fprintln(r.stdout, listedTest.Path)
+		}
+	default:
+		r.printSelection(testFiles)
+		fmt.Fprintf(r.stdout, "Test root: %s\n", testRoot)
+		writer := tabwriter.NewWriter(r.stdout, 0, 0, 2, ' ', tabwriter.DiscardEmptyColumns)
+		for _, listedTest := range listedTests {
+			metadata := ""
+			if listedTest.Metadata != nil {
+				metadata = listedTest.Metadata.String()
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", listedTest.Order, listedTest.TestFile, metadata, listedTest.Path)
+		}
+		writer.Flush()
+		if len(r.filteredTests) > 0 {
+			fmt.Fprintf(r.stdout, "Filtered out:\n")
+			for _, filteredTest := range r.filteredTests {
+				fmt.Fprintf(r.stdout, "\t%s: %s\n", filteredTest.TestFile, filteredTest.Reason)
+			}
+		}
+	}
+	return nil
+}
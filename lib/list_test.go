@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func setupListRunner(stdout *concurrentBuffer) (*Runner, string) {
+	testFolder, _ := filepath.Abs("../testdata/metadata")
+	r := setupDefaultRunner(stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+	r.options.ExcludeReStr = "plain"
+	return r, testFolder
+}
+
+func TestListCommandText(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	r, testFolder := setupListRunner(&stdout)
+	r.options.ExcludeReStr = "^$"
+	r.options.TestTargets = append(r.options.TestTargets, "../testdata/mixed/fail_test.sh")
+	if err := r.ListCommand(ListFormatText); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoot := filepath.Dir(testFolder)
+	expectedStdout := "Found 3 test files\n" +
+		fmt.Sprintf("Test root: %s\n", testRoot) +
+		fmt.Sprintf("1  metadata/plain_test.sh  owner=nobody                                                            %s/metadata/plain_test.sh\n", testRoot) +
+		fmt.Sprintf("2  metadata/slow_test.sh   timeout=1s tags=cf,slow owner=team-x requires=CF_DOMAIN flavor=vanilla  %s/metadata/slow_test.sh\n", testRoot) +
+		fmt.Sprintf("3  mixed/fail_test.sh                                                                              %s/mixed/fail_test.sh\n", testRoot)
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestListCommandPaths(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	r, testFolder := setupListRunner(&stdout)
+	r.options.ExcludeReStr = "^$"
+	if err := r.ListCommand(ListFormatPaths); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := filepath.Join(testFolder, "plain_test.sh") + "\n" +
+		filepath.Join(testFolder, "slow_test.sh") + "\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestListCommandJSON(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	r, testFolder := setupListRunner(&stdout)
+	if err := r.ListCommand(ListFormatJSON); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	var list jsonList
+	if err := json.NewDecoder(&stdout).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	expected := jsonList{
+		TestRoot: testFolder,
+		Seed:     -1,
+		InOrder:  true,
+		Tests: []ListedTest{
+			{
+				Order:    1,
+				TestFile: "slow_test.sh",
+				Path:     filepath.Join(testFolder, "slow_test.sh"),
+				Metadata: &TestMetadata{
+					Timeout:    1 * time.Second,
+					Tags:       []string{"cf", "slow"},
+					Owner:      "team-x",
+					Requires:   []string{"CF_DOMAIN"},
+					Properties: map[string]string{"flavor": "vanilla"},
+				},
+			},
+		},
+		Filtered: []FilteredTest{
+			{TestFile: "plain_test.sh", Reason: `path matches --exclude "plain"`},
+		},
+	}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("\nExpected:\n%+v\nHave:\n%+v\n", expected, list)
+	}
+}
+
+func TestListCommandUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	r, _ := setupListRunner(&stdout)
+	err := r.ListCommand("xml")
+	expected := `Unknown format "xml", expected text, json or paths`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, have %v", expected, err)
+	}
+}
@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// markdownOutputLines is the number of lines of output kept for each failed
+// test in the Markdown summary, which is meant to be read in a pull request or
+// a CI job summary rather than to hold the whole logs.
+const markdownOutputLines = 50
+
+// markdownReporter writes a Markdown summary of a run once it is done, to a
+// file, to the GitHub Actions job summary, or to both.
+type markdownReporter struct {
+	runner *Runner
+}
+
+func (reporter markdownReporter) RunStart(RunInfo)          {}
+func (reporter markdownReporter) TestStart(int, string)     {}
+func (reporter markdownReporter) TestOutput(string, []byte) {}
+func (reporter markdownReporter) TestEnd(FinishedTest)      {}
+func (reporter markdownReporter) RunEnd(results Results) error {
+	options := reporter.runner.options
+	summary := reporter.runner.markdownSummary(results)
+	if options.MarkdownFile != "" {
+		if err := writeFile(options.MarkdownFile, summary, os.O_TRUNC); err != nil {
+			return fmt.Errorf("Error writing Markdown summary: %s", err)
+		}
+	}
+	if options.StepSummaryFile != "" {
+		// GitHub Actions expects the steps to append to the job summary.
+		if err := writeFile(options.StepSummaryFile, summary, os.O_APPEND); err != nil {
+			return fmt.Errorf("Error writing GitHub step summary: %s", err)
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to a file, which is created if needed, and otherwise
+// truncated or appended to depending on flag.
+func writeFile(path string, data []byte, flag int) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// markdownSummary returns the Markdown summary of the results of a run.
+func (r *Runner) markdownSummary(results Results) []byte {
+	var summary bytes.Buffer
+	icon := ":white_check_mark:"
+	if len(results.Failed) > 0 || results.failedHooks() > 0 {
+		icon = ":x:"
+	} else if results.Interrupted {
+		icon = ":warning:"
+	}
+	fmt.Fprintf(&summary, "## %s testbrain results\n\n", icon)
+	fmt.Fprintf(&summary, "| Passed | Flaky | Skipped | Failed | Not run |\n")
+	fmt.Fprintf(&summary, "| ---: | ---: | ---: | ---: | ---: |\n")
+	fmt.Fprintf(&summary, "| %d | %d | %d | %d | %d |\n\n",
+		len(results.Passed), len(results.Flaky), len(results.Skipped), len(results.Failed), len(results.NotRun))
+	if results.Interrupted {
+		fmt.Fprintf(&summary, "The run was interrupted.\n\n")
+	}
+
+	if r.options.InOrder {
+		fmt.Fprintf(&summary, "The tests ran in order.\n\n")
+	} else {
+		fmt.Fprintf(&summary, "Seed: `%d`, run the tests in the same order with `--seed %d`.\n\n", r.options.RandomSeed, r.options.RandomSeed)
+	}
+	if shard := r.shardInfo(); shard != nil {
+		fmt.Fprintf(&summary, "Shard: `--shard-index %d --shard-total %d`.\n\n", shard.Index, shard.Total)
+	}
+
+	if len(results.Failed) > 0 {
+		fmt.Fprintf(&summary, "### Failed tests\n\n")
+		fmt.Fprintf(&summary, "| Test | Exit code | Duration |\n")
+		fmt.Fprintf(&summary, "| --- | ---: | ---: |\n")
+		for _, result := range results.Failed {
+			fmt.Fprintf(&summary, "| %s | %d | %v |\n", markdownCode(result.TestFile), result.ExitCode, formatDuration(result.Duration))
+		}
+		fmt.Fprintf(&summary, "\n")
+		for _, result := range results.Failed {
+			writeMarkdownDetails(&summary, fmt.Sprintf("%s failed with %s", result.TestFile, result.reason()), result.TestResult)
+		}
+	}
+
+	if results.failedHooks() > 0 {
+		fmt.Fprintf(&summary, "### Failed hooks\n\n")
+		for _, result := range results.Hooks {
+			if result.failed() {
+				writeMarkdownDetails(&summary, fmt.Sprintf("%s hook %s %s", result.Hook, result.TestFile, result.status()), result.TestResult)
+			}
+		}
+	}
+	return summary.Bytes()
+}
+
+// writeMarkdownDetails writes a collapsible block with the end of the output
+// of a test, and where its logs and artifacts are in the results directory.
+func writeMarkdownDetails(summary *bytes.Buffer, title string, result TestResult) {
+	fmt.Fprintf(summary, "<details>\n<summary>%s</summary>\n\n", markdownEscaper.Replace(title))
+	if result.ResultsDir != "" {
+		fmt.Fprintf(summary, "Logs: %s\n\n", markdownCode(filepath.Join(result.ResultsDir, outputLogName)))
+	}
+	if len(result.Artifacts) > 0 {
+		var paths []string
+		for _, path := range result.artifactPaths() {
+			paths = append(paths, markdownCode(path))
+		}
+		fmt.Fprintf(summary, "Artifacts: %s\n\n", strings.Join(paths, ", "))
+	}
+	output := strings.TrimRight(result.Output, "\n")
+	if output == "" {
+		fmt.Fprintf(summary, "No output.\n\n</details>\n\n")
+		return
+	}
+	lines := strings.Split(output, "\n")
+	if len(lines) > markdownOutputLines {
+		fmt.Fprintf(summary, "Last %d of %d lines of output:\n\n", markdownOutputLines, len(lines))
+		lines = lines[len(lines)-markdownOutputLines:]
+	}
+	fence := markdownFence(output)
+	fmt.Fprintf(summary, "%s\n%s\n%s\n\n</details>\n\n", fence, strings.Join(lines, "\n"), fence)
+}
+
+// markdownEscaper escapes the text of the summary of a details block, which is
+// HTML.
+var markdownEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// markdownCode formats a test name as code in a table cell.
+func markdownCode(text string) string {
+	return "`" + strings.Replace(text, "|", "\\|", -1) + "`"
+}
+
+// markdownFence returns a code fence longer than any run of backticks in the
+// text, so that the text cannot end the code block.
+func markdownFence(text string) string {
+	longest, run := 0, 0
+	for _, char := range text {
+		if char == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
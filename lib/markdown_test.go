@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdownSummary(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.RandomSeed = 42
+	results := Results{
+		Passed:  []PassedResult{{TestFile: "testfile-success"}},
+		Skipped: []SkippedResult{{TestFile: "testfile-skip"}},
+		Failed: []FailedResult{
+			{
+				TestResult: TestResult{
+					TestFile: "dir/testfile|failure",
+					Duration: 1500 * time.Millisecond,
+					Output:   "Goodbye <World>!\n",
+				},
+				ExitCode: 42,
+			},
+			{
+				TestResult: TestResult{TestFile: "testfile-silent"},
+				ExitCode:   1,
+			},
+		},
+		Hooks: []HookResult{
+			{TestResult: TestResult{TestFile: "setup.sh", Output: "```\nNo cluster\n"}, Hook: setupHook, ExitCode: 3},
+			{TestResult: TestResult{TestFile: "teardown.sh"}, Hook: teardownHook},
+		},
+	}
+
+	expected := "## :x: testbrain results\n\n" +
+		"| Passed | Flaky | Skipped | Failed | Not run |\n" +
+		"| ---: | ---: | ---: | ---: | ---: |\n" +
+		"| 1 | 0 | 1 | 2 | 0 |\n\n" +
+		"Seed: `42`, run the tests in the same order with `--seed 42`.\n\n" +
+		"### Failed tests\n\n" +
+		"| Test | Exit code | Duration |\n" +
+		"| --- | ---: | ---: |\n" +
+		"| `dir/testfile\\|failure` | 42 | 1.5s |\n" +
+		"| `testfile-silent` | 1 | 0s |\n\n" +
+		"<details>\n<summary>dir/testfile|failure failed with exit code 42</summary>\n\n" +
+		"```\nGoodbye <World>!\n```\n\n</details>\n\n" +
+		"<details>\n<summary>testfile-silent failed with exit code 1</summary>\n\n" +
+		"No output.\n\n</details>\n\n" +
+		"### Failed hooks\n\n" +
+		"<details>\n<summary>setup hook setup.sh failed with exit code 3</summary>\n\n" +
+		"````\n```\nNo cluster\n````\n\n</details>\n\n"
+	if summary := string(r.markdownSummary(results)); summary != expected {
+		t.Errorf("\nExpected summary:\n%s\nHave:\n%s\n", expected, summary)
+	}
+
+	r.options.InOrder = true
+	results = Results{Passed: []PassedResult{{TestFile: "testfile-success"}}}
+	expected = "## :white_check_mark: testbrain results\n\n" +
+		"| Passed | Flaky | Skipped | Failed | Not run |\n" +
+		"| ---: | ---: | ---: | ---: | ---: |\n" +
+		"| 1 | 0 | 0 | 0 | 0 |\n\n" +
+		"The tests ran in order.\n\n"
+	if summary := string(r.markdownSummary(results)); summary != expected {
+		t.Errorf("\nExpected summary:\n%s\nHave:\n%s\n", expected, summary)
+	}
+}
+
+func TestMarkdownSummaryTruncatesOutput(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	for i := 1; i <= 60; i++ {
+		fmt.Fprintf(&output, "line %d\n", i)
+	}
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	results := Results{
+		Failed: []FailedResult{{TestResult: TestResult{TestFile: "testfile-failure", Output: output.String()}, ExitCode: 1}},
+	}
+	summary := string(r.markdownSummary(results))
+	expectedPart := "Last 50 of 60 lines of output:\n\n```\nline 11\n"
+	if !strings.Contains(summary, expectedPart) || strings.Contains(summary, "line 10\n") || !strings.Contains(summary, "line 60\n```") {
+		t.Errorf("Expected the output to be truncated to its last 50 lines, have:\n%s", summary)
+	}
+}
+
+func TestMarkdownSummaryResultsDir(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	results := Results{
+		Failed: []FailedResult{{
+			TestResult: TestResult{
+				TestFile:   "a_test.sh",
+				ResultsDir: "results/a_test.sh",
+				Artifacts:  []string{"artifacts/cf.log", "artifacts/screenshot.png"},
+			},
+			ExitCode: 1,
+		}},
+	}
+	expected := "<details>\n<summary>a_test.sh failed with exit code 1</summary>\n\n" +
+		"Logs: `" + filepath.Join("results", "a_test.sh", "output.log") + "`\n\n" +
+		"Artifacts: `" + filepath.Join("results", "a_test.sh", "artifacts", "cf.log") + "`, `" +
+		filepath.Join("results", "a_test.sh", "artifacts", "screenshot.png") + "`\n\n" +
+		"No output.\n\n</details>\n\n"
+	if summary := string(r.markdownSummary(results)); !strings.Contains(summary, expected) {
+		t.Errorf("\nExpected the summary to contain:\n%s\nHave:\n%s\n", expected, summary)
+	}
+}
+
+func TestRunCommandMarkdown(t *testing.T) {
+	t.Parallel()
+
+	tempDir, err := ioutil.TempDir("", "testbrain-markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	stepSummaryFile := filepath.Join(tempDir, "step_summary.md")
+	if err := ioutil.WriteFile(stepSummaryFile, []byte("Earlier step\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.MarkdownFile = filepath.Join(tempDir, "summary.md")
+	r.options.StepSummaryFile = stepSummaryFile
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	summary, err := ioutil.ReadFile(r.options.MarkdownFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedParts := []string{
+		"| 1 | 0 | 1 | 1 | 0 |\n",
+		"| `fail_test.sh` | 42 | ",
+		"<summary>fail_test.sh failed with exit code 42</summary>\n\n```\nGoodbye World!\n```\n",
+	}
+	for _, part := range expectedParts {
+		if !strings.Contains(string(summary), part) {
+			t.Errorf("Expected the Markdown summary to contain %q, have:\n%s", part, summary)
+		}
+	}
+
+	stepSummary, err := ioutil.ReadFile(stepSummaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Earlier step\n" + string(summary); string(stepSummary) != expected {
+		t.Errorf("\nExpected the step summary:\n%s\nHave:\n%s\n", expected, stepSummary)
+	}
+}
+
+func TestMarkdownFence(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{text: "", expected: "```"},
+		{text: "`code` and ``more``", expected: "```"},
+		{text: "```\nblock\n```", expected: "````"},
+		{text: "`````", expected: "``````"},
+	}
+	for _, tt := range tests {
+		if fence := markdownFence(tt.text); fence != tt.expected {
+			t.Errorf("Expected the fence of %q to be %q, have %q", tt.text, tt.expected, fence)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataRe matches the header comment lines declaring metadata, such as
+// "# testbrain: timeout=900 tags=cf,slow owner=team-x requires=CF_DOMAIN".
+var metadataRe = regexp.MustCompile(`^#\s*testbrain:(.*)$`)
+
+// tagsRe matches the header comment lines declaring tags only, such as
+// "# tags: smoke,cf".
+var tagsRe = regexp.MustCompile(`^#\s*tags:(.*)$`)
+
+// TestMetadata is the metadata a test script declares in its header comments.
+type TestMetadata struct {
+	// Timeout overrides the global timeout for this test.
+	Timeout  time.Duration `json:"timeout,omitempty"`
+	Tags     []string      `json:"tags,omitempty"`
+	Owner    string        `json:"owner,omitempty"`
+	Requires []string      `json:"requires,omitempty"`
+	// Executables lists the commands the test needs in the PATH, as Requires
+	// lists the environment variables it needs.
+	Executables []string `json:"executables,omitempty"`
+	// TAP tells that the TAP the test prints is parsed into sub-tests.
+	TAP bool `json:"tap,omitempty"`
+	// Properties holds any other key=value pairs.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// IsEmpty tells whether no metadata was declared.
+func (metadata TestMetadata) IsEmpty() bool {
+	return metadata.Timeout == 0 &&
+		len(metadata.Tags) == 0 &&
+		metadata.Owner == "" &&
+		len(metadata.Requires) == 0 &&
+		len(metadata.Executables) == 0 &&
+		!metadata.TAP &&
+		len(metadata.Properties) == 0
+}
+
+func (metadata TestMetadata) String() string {
+	var fields []string
+	if metadata.Timeout != 0 {
+		fields = append(fields, fmt.Sprintf("timeout=%v", metadata.Timeout))
+	}
+	if len(metadata.Tags) > 0 {
+		fields = append(fields, "tags="+strings.Join(metadata.Tags, ","))
+	}
+	if metadata.Owner != "" {
+		fields = append(fields, "owner="+metadata.Owner)
+	}
+	if len(metadata.Requires) > 0 {
+		fields = append(fields, "requires="+strings.Join(metadata.Requires, ","))
+	}
+	if len(metadata.Executables) > 0 {
+		fields = append(fields, "executables="+strings.Join(metadata.Executables, ","))
+	}
+	if metadata.TAP {
+		fields = append(fields, "tap=true")
+	}
+	var keys []string
+	for key := range metadata.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, key+"="+metadata.Properties[key])
+	}
+	return strings.Join(fields, " ")
+}
+
+// readTestMetadata reads the metadata from the header of a test script, that
+// is the comment lines at the top of the file.  Invalid fields are ignored, and
+// reported as warnings, so that they only affect the test declaring them.
+func readTestMetadata(testPath string) (TestMetadata, []string, error) {
+	var metadata TestMetadata
+	var warnings []string
+
+	file, err := os.Open(testPath)
+	if err != nil {
+		return metadata, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			// The header is over.
+			break
+		}
+		if match := tagsRe.FindStringSubmatch(line); match != nil {
+			metadata.Tags = append(metadata.Tags, splitList(match[1])...)
+			continue
+		}
+		match := metadataRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		for _, field := range strings.Fields(match[1]) {
+			if err := metadata.set(field); err != nil {
+				warnings = append(warnings, fmt.Sprintf("Ignoring metadata on line %d of %s: %s", lineNumber, testPath, err))
+			}
+		}
+	}
+	return metadata, warnings, scanner.Err()
+}
+
+// set sets the metadata from a key=value field.
+func (metadata *TestMetadata) set(field string) error {
+	parts := strings.SplitN(field, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("Invalid metadata %q, expected key=value", field)
+	}
+	key, value := parts[0], parts[1]
+	switch key {
+	case "timeout":
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			return fmt.Errorf("Invalid timeout %q: %s", value, err)
+		}
+		metadata.Timeout = timeout
+	case "tags":
+		metadata.Tags = append(metadata.Tags, splitList(value)...)
+	case "owner":
+		metadata.Owner = value
+	case "requires":
+		metadata.Requires = append(metadata.Requires, splitList(value)...)
+	case "executables":
+		metadata.Executables = append(metadata.Executables, splitList(value)...)
+	case "tap":
+		tap, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid tap %q, expected true or false", value)
+		}
+		metadata.TAP = tap
+	default:
+		if metadata.Properties == nil {
+			metadata.Properties = make(map[string]string)
+		}
+		metadata.Properties[key] = value
+	}
+	return nil
+}
+
+// parseTimeout parses a timeout given either in seconds, like the --timeout
+// flag, or as a duration such as "15m".
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0, fmt.Errorf("must be positive")
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return timeout, nil
+}
+
+// splitList splits a comma separated list, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
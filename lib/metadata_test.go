@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadTestMetadata(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/metadata")
+
+	tests := []struct {
+		testFile string
+		expected TestMetadata
+	}{
+		{
+			testFile: "slow_test.sh",
+			expected: TestMetadata{
+				Timeout:    1 * time.Second,
+				Tags:       []string{"cf", "slow"},
+				Owner:      "team-x",
+				Requires:   []string{"CF_DOMAIN"},
+				Properties: map[string]string{"flavor": "vanilla"},
+			},
+		},
+		{
+			testFile: "plain_test.sh",
+			expected: TestMetadata{
+				Owner: "nobody",
+			},
+		},
+	}
+	for _, tt := range tests {
+		metadata, warnings, err := readTestMetadata(filepath.Join(testFolder, tt.testFile))
+		if err != nil {
+			t.Errorf("Error reading metadata of %s: %s", tt.testFile, err)
+			continue
+		}
+		if len(warnings) > 0 {
+			t.Errorf("Unexpected warnings reading metadata of %s: %v", tt.testFile, warnings)
+		}
+		if !reflect.DeepEqual(metadata, tt.expected) {
+			t.Errorf("\nExpected metadata of %s:\n%#v\nHave:\n%#v\n", tt.testFile, tt.expected, metadata)
+		}
+	}
+}
+
+func TestGetTestScriptsInvalidMetadata(t *testing.T) {
+	t.Parallel()
+
+	testFolder, err := ioutil.TempDir("", "testbrain-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testFolder)
+	writeScript := func(name string, content string) {
+		if err := ioutil.WriteFile(filepath.Join(testFolder, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeScript("invalid_test.sh", "#!/bin/bash\n# testbrain: owner=team-x\n# testbrain: tap=maybe tags=cf\nexit 0\n")
+	writeScript("valid_test.sh", "#!/bin/bash\n# testbrain: owner=team-y\nexit 0\n")
+
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(ioutil.Discard, &stderr)
+	r.options.TestTargets = []string{testFolder}
+	_, testScripts, err := r.getTestScripts()
+	if err != nil {
+		t.Fatalf("Error getting test scripts: %s", err)
+	}
+	expectedScripts := []string{"invalid_test.sh", "valid_test.sh"}
+	if !reflect.DeepEqual(testScripts, expectedScripts) {
+		t.Errorf("\nExpected:\n%v\nHave:\n%v\n", expectedScripts, testScripts)
+	}
+	// The other fields of the test are kept.
+	expectedMetadata := TestMetadata{Owner: "team-x", Tags: []string{"cf"}}
+	if metadata := r.testMetadata("invalid_test.sh"); metadata == nil || !reflect.DeepEqual(*metadata, expectedMetadata) {
+		t.Errorf("\nExpected metadata:\n%#v\nHave:\n%#v\n", expectedMetadata, metadata)
+	}
+
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStderr := "Warning: Ignoring metadata on line 3 of " + filepath.Join(testFolder, "invalid_test.sh") +
+		": Invalid tap \"maybe\", expected true or false\n"
+	if stderrStr := string(stderrBytes); stderrStr != expectedStderr {
+		t.Errorf("\nExpected stderr:\n%q\n\nHave:\n%q\n", expectedStderr, stderrStr)
+	}
+}
+
+func TestTestMetadataSet(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		field   string
+		timeout time.Duration
+		valid   bool
+	}{
+		{field: "timeout=900", timeout: 900 * time.Second, valid: true},
+		{field: "timeout=15m", timeout: 15 * time.Minute, valid: true},
+		{field: "timeout=0", valid: false},
+		{field: "timeout=-5s", valid: false},
+		{field: "timeout=soon", valid: false},
+		{field: "timeout", valid: false},
+		{field: "=value", valid: false},
+	}
+	for _, tt := range tests {
+		var metadata TestMetadata
+		err := metadata.set(tt.field)
+		if tt.valid && err != nil {
+			t.Errorf("Unexpected error setting %q: %s", tt.field, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Expected an error setting %q, got nothing", tt.field)
+		}
+		if metadata.Timeout != tt.timeout {
+			t.Errorf("\nExpected timeout from %q: %v\nHave: %v\n", tt.field, tt.timeout, metadata.Timeout)
+		}
+	}
+}
+
+func TestTestMetadataString(t *testing.T) {
+	t.Parallel()
+
+	metadata := TestMetadata{
+		Timeout:    15 * time.Minute,
+		Tags:       []string{"cf", "slow"},
+		Owner:      "team-x",
+		Requires:   []string{"CF_DOMAIN", "ORG"},
+		Properties: map[string]string{"zone": "a", "flavor": "vanilla"},
+	}
+	metadata.Executables = []string{"cf", "jq"}
+	expected := "timeout=15m0s tags=cf,slow owner=team-x requires=CF_DOMAIN,ORG executables=cf,jq flavor=vanilla zone=a"
+	if str := metadata.String(); str != expected {
+		t.Errorf("\nExpected:\n%q\nHave:\n%q\n", expected, str)
+	}
+}
+
+// TestRunCommandMetadataTimeout does not run in parallel, as it sets the
+// environment variable the test requires.
+func TestRunCommandMetadataTimeout(t *testing.T) {
+	testFolder, _ := filepath.Abs("../testdata/metadata")
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(ioutil.Discard, &stderr)
+	r.options.TestTargets = []string{testFolder}
+	r.options.IncludeReStr = "slow"
+	// The test is skipped unless the variable it requires is set.
+	if domain, ok := os.LookupEnv("CF_DOMAIN"); ok {
+		defer os.Setenv("CF_DOMAIN", domain)
+	} else {
+		defer os.Unsetenv("CF_DOMAIN")
+	}
+	os.Setenv("CF_DOMAIN", "example.com")
+
+	start := time.Now()
+	if err := r.RunCommand(); err == nil {
+		t.Errorf("Expected an error, got nothing")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("The timeout of the test was not applied, it ran for %v", elapsed)
+	}
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStderr := "Killed by testbrain: Timed out after 1s, terminated by SIGKILL\n"
+	if stderrStr := string(stderrBytes); stderrStr != expectedStderr {
+		t.Errorf("\nExpected stderr:\n%q\n\nHave:\n%q\n", expectedStderr, stderrStr)
+	}
+}
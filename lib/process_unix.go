@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package lib
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// setProcessGroup makes the command start in a new process group, so that the
+// test script and every process it spawns can be signalled at once.
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup sends the signal to every process in the process group
+// of the (already started) command.
+func signalProcessGroup(command *exec.Cmd, signal syscall.Signal) error {
+	return syscall.Kill(-command.Process.Pid, signal)
+}
+
+// maxRSS returns the maximum resident set size of the process, in kilobytes.
+func maxRSS(state *os.ProcessState) int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		// Darwin reports it in bytes rather than kilobytes.
+		return int64(rusage.Maxrss) / 1024
+	}
+	return int64(rusage.Maxrss)
+}
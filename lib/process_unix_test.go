@@ -0,0 +1,67 @@
+//go:build !windows
+// +build !windows
+
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processAlive tells whether a process is running, zombies aside, as they may
+// not be reaped when the tests run in a container.
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	state, err := exec.Command("ps", "-o", "stat=", "-p", fmt.Sprint(pid)).Output()
+	return err == nil && !strings.HasPrefix(strings.TrimSpace(string(state)), "Z")
+}
+
+func TestRunSingleTestTimeoutKillsChildren(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(&stdout, &stderr)
+	r.options.Timeout = 1 * time.Second
+	r.options.KillGracePeriod = 5 * time.Second
+
+	testFolder, _ := filepath.Abs("../testdata")
+	exitCode, _ := r.runSingleTest("orphan_test.sh", testFolder, "", "", r.interrupted, &stdout, &stderr)
+	if exitCode != unknownExitCode {
+		t.Errorf("\nExpected ExitCode: %v\nHave: %v\n", unknownExitCode, exitCode)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pid int
+	if _, err := fmt.Sscanf(string(stdoutBytes), "Child %d\n", &pid); err != nil {
+		t.Fatalf("Error reading the pid of the child in %q: %s", stdoutBytes, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("The child of the test ignoring SIGTERM is still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStderr := "Killed by testbrain: Timed out after 1s, terminated by SIGTERM\n"
+	if stderrStr := string(stderrBytes); stderrStr != expectedStderr {
+		t.Errorf("\nExpected stderr:\n%q\n\nHave:\n%q\n", expectedStderr, stderrStr)
+	}
+}
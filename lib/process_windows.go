@@ -0,0 +1,30 @@
+//go:build windows
+// +build windows
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is a no-op on Windows, which has no process groups that can
+// be signalled.
+func setProcessGroup(command *exec.Cmd) {
+}
+
+// signalProcessGroup kills the process of the command.  Windows cannot deliver
+// other signals, so anything but SIGKILL is reported as an error.
+func signalProcessGroup(command *exec.Cmd, signal syscall.Signal) error {
+	if signal != syscall.SIGKILL {
+		return fmt.Errorf("Sending %v is not supported on Windows", signal)
+	}
+	return command.Process.Kill()
+}
+
+// maxRSS is not available on Windows.
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// readJSONResults reads the results of an earlier run, as output with either
+// --json or --json-stream.
+func readJSONResults(path string) (jsonResults, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return jsonResults{}, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		// Either the results of --json, or an event of --json-stream, of
+		// which only the runEnd event holds the results.
+		var value struct {
+			jsonResults
+			Action  string       `json:"action"`
+			Summary *jsonResults `json:"summary"`
+		}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return jsonResults{}, errors.New("No results found, expected the output of --json or --json-stream")
+		}
+		if err != nil {
+			return jsonResults{}, fmt.Errorf("Error parsing JSON: %s", err)
+		}
+		if value.Action == "" {
+			return value.jsonResults, nil
+		}
+		if value.Action == streamActionRunEnd && value.Summary != nil {
+			return *value.Summary, nil
+		}
+	}
+}
+
+// durations returns the durations of the tests that were run, by test file.
+func (results jsonResults) durations() map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, result := range results.PassedList {
+		durations[result.TestFile] = result.Duration
+	}
+	for _, result := range results.FlakyList {
+		durations[result.TestFile] = result.Duration
+	}
+	for _, result := range results.SkippedList {
+		if result.Duration > 0 {
+			// Otherwise it was skipped without being run.
+			durations[result.TestFile] = result.Duration
+		}
+	}
+	for _, result := range results.FailedList {
+		durations[result.TestFile] = result.Duration
+	}
+	return durations
+}
+
+// withStatus returns the results of the tests with the given status, as in
+// the testEnd events of the JSON stream output.
+func (results jsonResults) withStatus(status string) ([]TestResult, error) {
+	var list []TestResult
+	switch status {
+	case StatusPassed:
+		for _, result := range results.PassedList {
+			list = append(list, TestResult(result))
+		}
+	case StatusFlaky:
+		for _, result := range results.FlakyList {
+			list = append(list, TestResult(result))
+		}
+	case StatusSkipped:
+		for _, result := range results.SkippedList {
+			list = append(list, TestResult(result))
+		}
+	case StatusFailed:
+		for _, result := range results.FailedList {
+			list = append(list, result.TestResult)
+		}
+	case StatusNotRun:
+		for _, result := range results.NotRunList {
+			list = append(list, TestResult(result))
+		}
+	default:
+		return nil, fmt.Errorf("Unknown status %q, expected one of %s, %s, %s, %s or %s", status,
+			StatusPassed, StatusFlaky, StatusSkipped, StatusFailed, StatusNotRun)
+	}
+	return list, nil
+}
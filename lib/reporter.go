@@ -0,0 +1,280 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Statuses of the tests told to the reporters, as in the testEnd events of the
+// JSON stream output.  Hooks are either passed, failed or not run.
+const (
+	StatusPassed  = "passed"
+	StatusFlaky   = "flaky"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+	StatusNotRun  = "notRun"
+)
+
+// Reporter is told about the progress of a run, so that it can report it, for
+// example by printing it or by writing a file.  A runner reports to the
+// reporter of its output options, then to the ones added with AddReporter.
+//
+// When tests run concurrently, the methods are called from several goroutines
+// at once, but the calls about each test are made in order.
+type Reporter interface {
+	// RunStart is called once the tests to run are known.
+	RunStart(run RunInfo)
+	// TestStart is called when the test at index in the run, counting from 0,
+	// is started, or in the current round of tests of WatchCommand.  It is
+	// not called for the tests that are skipped or failed without being run.
+	TestStart(index int, testFile string)
+	// TestOutput is called with the output of a test, or of a hook, as it is
+	// printed.  The output is only valid until TestOutput returns.
+	TestOutput(testFile string, output []byte)
+	// TestEnd is called once a test is done.
+	TestEnd(test FinishedTest)
+	// RunEnd is called with the results of the run once all the tests are
+	// done, or after each round of tests of WatchCommand.  Its error is
+	// printed, and returned by RunCommand.
+	RunEnd(results Results) error
+}
+
+// RetryReporter is implemented by the reporters that are also told about the
+// failed attempts of the tests that are retried.
+type RetryReporter interface {
+	// TestRetry is called when the test at index in the run failed, and is
+	// about to be run again.
+	TestRetry(index int, attempt FailedResult)
+}
+
+// HookReporter is implemented by the reporters that are also told about the
+// setup and teardown hooks.
+type HookReporter interface {
+	// HookStart is called when a setup or teardown hook is started.
+	HookStart(hook string, script string)
+	// HookEnd is called once a hook is done.
+	HookEnd(result HookResult)
+}
+
+// dryRunReporter is implemented by the reporters listing the tests of a dry
+// run.
+type dryRunReporter interface {
+	dryRun(testRoot string, testFiles []string)
+}
+
+// stderrReporter is implemented by the reporters printing the stderr of the
+// tests apart from their stdout.  They are told about it with testStderr rather
+// than TestOutput, when the tests run one at a time.
+type stderrReporter interface {
+	testStderr(testFile string, output []byte)
+}
+
+// RunInfo describes a run to the reporters.
+type RunInfo struct {
+	// Seed is the seed the tests were shuffled with, or -1 when they run in
+	// order.
+	Seed    int64
+	InOrder bool
+	// Shard is the shard of the tests that runs, or nil if the tests are not
+	// split.
+	Shard    *ShardInfo
+	TestRoot string
+	// Tests are the paths of the tests to run, relative to TestRoot, in the
+	// order they run.
+	Tests []string
+}
+
+// FinishedTest describes a test that is done to the reporters.
+type FinishedTest struct {
+	// Index is the position of the test in the run, counting from 0.
+	Index int
+	// Status is one of StatusPassed, StatusFlaky, StatusSkipped, StatusFailed,
+	// or StatusNotRun when the test was terminated as the run was interrupted.
+	Status string
+	// ExitCode is the exit code of the test, or -1 when it is unknown.  It is
+	// 0 for the tests that only failed because of their sub-tests.
+	ExitCode int
+	Result   TestResult
+}
+
+// newFinishedTest describes a test from its result, and its exit code as
+// returned by runAttempt.
+func newFinishedTest(index int, result TestResult, exitCode int) FinishedTest {
+	test := FinishedTest{Index: index, Result: result, ExitCode: exitCode}
+	if exitCode == interruptedExitCode {
+		test.Status = StatusNotRun
+		test.ExitCode = unknownExitCode
+	} else if exitCode == skipTestExitCode {
+		test.Status = StatusSkipped
+	} else if exitCode == 0 && len(result.FailedAttempts) > 0 {
+		test.Status = StatusFlaky
+	} else if exitCode == 0 {
+		test.Status = StatusPassed
+	} else {
+		test.Status = StatusFailed
+		test.ExitCode = newFailedResult(result, exitCode).ExitCode
+	}
+	return test
+}
+
+// AddReporter adds a reporter told about the progress of the runs, after the
+// reporter of the output options.
+func (r *Runner) AddReporter(reporter Reporter) {
+	r.addedReporters = append(r.addedReporters, reporter)
+}
+
+// setupReporters sets the reporters of a run: the one of the output options,
+// the ones writing the JUnit, HTML and Markdown reports, the results directory
+// and the CI annotations if any, and the ones that were added.
+func (r *Runner) setupReporters() {
+	var reporters []Reporter
+	if r.options.JSONStreamOutput {
+		reporters = append(reporters, newJSONStream(r))
+	} else if r.options.JSONOutput {
+		reporters = append(reporters, jsonReporter{runner: r})
+	} else if r.options.TAPOutput {
+		reporters = append(reporters, newTAPStream(r.stdout))
+	} else {
+		reporters = append(reporters, newTextReporter(r))
+	}
+	if r.options.JUnitFile != "" {
+		reporters = append(reporters, junitReporter{runner: r})
+	}
+	if r.options.HTMLFile != "" {
+		reporters = append(reporters, newHTMLReporter(r))
+	}
+	if r.options.MarkdownFile != "" || r.options.StepSummaryFile != "" {
+		reporters = append(reporters, markdownReporter{runner: r})
+	}
+	if r.options.ResultsDir != "" {
+		reporters = append(reporters, resultsDirReporter{runner: r})
+	}
+	if r.options.CIAnnotations != "" {
+		reporters = append(reporters, newCIAnnotations(r))
+	}
+	r.reporters = append(reporters, r.addedReporters...)
+}
+
+func (r *Runner) runStart(testRoot string, testFiles []string) {
+	run := RunInfo{
+		Seed:     r.displayedSeed(),
+		InOrder:  r.options.InOrder,
+		Shard:    r.shardInfo(),
+		TestRoot: testRoot,
+		Tests:    testFiles,
+	}
+	for _, reporter := range r.reporters {
+		reporter.RunStart(run)
+	}
+}
+
+func (r *Runner) dryRun(testRoot string, testFiles []string) {
+	for _, reporter := range r.reporters {
+		if reporter, ok := reporter.(dryRunReporter); ok {
+			reporter.dryRun(testRoot, testFiles)
+		}
+	}
+}
+
+func (r *Runner) testStart(index int, testFile string) {
+	for _, reporter := range r.reporters {
+		reporter.TestStart(index, testFile)
+	}
+}
+
+func (r *Runner) testRetry(index int, attempt FailedResult) {
+	for _, reporter := range r.reporters {
+		if reporter, ok := reporter.(RetryReporter); ok {
+			reporter.TestRetry(index, attempt)
+		}
+	}
+}
+
+func (r *Runner) testEnd(index int, result TestResult, exitCode int) {
+	test := newFinishedTest(index, result, exitCode)
+	for _, reporter := range r.reporters {
+		reporter.TestEnd(test)
+	}
+}
+
+func (r *Runner) hookStart(hook string, script string) {
+	for _, reporter := range r.reporters {
+		if reporter, ok := reporter.(HookReporter); ok {
+			reporter.HookStart(hook, script)
+		}
+	}
+}
+
+func (r *Runner) hookEnd(result HookResult) {
+	for _, reporter := range r.reporters {
+		if reporter, ok := reporter.(HookReporter); ok {
+			reporter.HookEnd(result)
+		}
+	}
+}
+
+// runEnd reports the results of a run, and returns the first error of the
+// reporters, after printing them all.
+func (r *Runner) runEnd(results Results) error {
+	var firstErr error
+	for _, reporter := range r.reporters {
+		if err := reporter.RunEnd(results); err != nil {
+			fmt.Fprintln(r.stderr, redBold(err.Error()))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// outputWriter returns a writer passing the output of a test, or of a hook,
+// to the reporters.
+func (r *Runner) outputWriter(testFile string) io.Writer {
+	return &reporterOutputWriter{mutex: &sync.Mutex{}, reporters: r.reporters, testFile: testFile}
+}
+
+// outputWriters returns writers passing the stdout and the stderr of a test,
+// or of a hook, to the reporters: apart to the ones printing them apart, and
+// both as its output to the others.  They can be used by separate goroutines.
+func (r *Runner) outputWriters(testFile string) (io.Writer, io.Writer) {
+	mutex := &sync.Mutex{}
+	return &reporterOutputWriter{mutex: mutex, reporters: r.reporters, testFile: testFile},
+		&reporterOutputWriter{mutex: mutex, reporters: r.reporters, testFile: testFile, stderr: true}
+}
+
+type reporterOutputWriter struct {
+	// mutex serializes the calls to the reporters about the test.
+	mutex     *sync.Mutex
+	reporters []Reporter
+	testFile  string
+	stderr    bool
+}
+
+func (w *reporterOutputWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, reporter := range w.reporters {
+		if stderrReporter, ok := reporter.(stderrReporter); ok && w.stderr {
+			stderrReporter.testStderr(w.testFile, p)
+		} else {
+			reporter.TestOutput(w.testFile, p)
+		}
+	}
+	return len(p), nil
+}
+
+// jsonReporter outputs the results of a run in JSON, once it is done.
+type jsonReporter struct {
+	runner *Runner
+}
+
+func (reporter jsonReporter) RunStart(RunInfo)          {}
+func (reporter jsonReporter) TestStart(int, string)     {}
+func (reporter jsonReporter) TestOutput(string, []byte) {}
+func (reporter jsonReporter) TestEnd(FinishedTest)      {}
+func (reporter jsonReporter) RunEnd(results Results) error {
+	reporter.runner.outputResultsJSON(results)
+	return nil
+}
@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingReporter records what it is told about a run.
+type recordingReporter struct {
+	mutex  sync.Mutex
+	events []string
+	output map[string]string
+	err    error
+}
+
+func newRecordingReporter() *recordingReporter {
+	return &recordingReporter{output: make(map[string]string)}
+}
+
+func (reporter *recordingReporter) record(format string, args ...interface{}) {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	reporter.events = append(reporter.events, fmt.Sprintf(format, args...))
+}
+
+func (reporter *recordingReporter) RunStart(run RunInfo) {
+	reporter.record("runStart %s", strings.Join(run.Tests, ","))
+}
+
+func (reporter *recordingReporter) TestStart(index int, testFile string) {
+	reporter.record("testStart %d %s", index, testFile)
+}
+
+func (reporter *recordingReporter) TestOutput(testFile string, output []byte) {
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	reporter.output[testFile] += string(output)
+}
+
+func (reporter *recordingReporter) TestEnd(test FinishedTest) {
+	reporter.record("testEnd %d %s %s %d", test.Index, test.Result.TestFile, test.Status, test.ExitCode)
+}
+
+func (reporter *recordingReporter) RunEnd(results Results) error {
+	reporter.record("runEnd %d passed, %d skipped, %d failed", len(results.Passed), len(results.Skipped), len(results.Failed))
+	return reporter.err
+}
+
+func TestRunCommandReporters(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+	reporters := []*recordingReporter{newRecordingReporter(), newRecordingReporter()}
+	for _, reporter := range reporters {
+		r.AddReporter(reporter)
+	}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	expectedEvents := []string{
+		"runStart fail_test.sh,skip_test.sh,success_test.sh",
+		"testStart 0 fail_test.sh",
+		"testEnd 0 fail_test.sh failed 42",
+		"testStart 1 skip_test.sh",
+		"testEnd 1 skip_test.sh skipped 99",
+		"testStart 2 success_test.sh",
+		"testEnd 2 success_test.sh passed 0",
+		"runEnd 1 passed, 1 skipped, 1 failed",
+	}
+	expectedOutput := map[string]string{
+		"fail_test.sh":    "Goodbye World!\n",
+		"skip_test.sh":    "Something stdout\nSomething stderr\n",
+		"success_test.sh": "Hello World!\n",
+	}
+	for _, reporter := range reporters {
+		if !reflect.DeepEqual(reporter.events, expectedEvents) {
+			t.Errorf("\nExpected events:\n%q\nHave:\n%q\n", expectedEvents, reporter.events)
+		}
+		if !reflect.DeepEqual(reporter.output, expectedOutput) {
+			t.Errorf("\nExpected output:\n%q\nHave:\n%q\n", expectedOutput, reporter.output)
+		}
+	}
+
+	// The reporter of the output options still prints the results.
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdoutStr := string(stdoutBytes); !strings.Contains(stdoutStr, "Tests complete: 1 Passed, 1 Skipped, 1 Failed\n") {
+		t.Errorf("Expected the text results on stdout, have:\n%s", stdoutStr)
+	}
+}
+
+func TestRunCommandReporterError(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/success")
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(ioutil.Discard, &stderr)
+	r.options.TestTargets = []string{testFolder}
+	failing := newRecordingReporter()
+	failing.err = errors.New("Error writing report: disk full")
+	following := newRecordingReporter()
+	r.AddReporter(failing)
+	r.AddReporter(following)
+
+	if err := r.RunCommand(); err != failing.err {
+		t.Errorf("Expected the error of the reporter, have %v", err)
+	}
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stderrBytes), failing.err.Error()) {
+		t.Errorf("Expected the error of the reporter on stderr, have %q", stderrBytes)
+	}
+	if last := following.events[len(following.events)-1]; !strings.HasPrefix(last, "runEnd") {
+		t.Errorf("Expected the following reporter to be told the run ended, have %q", last)
+	}
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// unmetRequirements returns the requirements declared in the metadata of a
+// test that are not met: the environment variables that are unset or empty,
+// and the executables that cannot be found in the PATH.
+func (r *Runner) unmetRequirements(testFile string) []string {
+	metadata := r.testMetadata(testFile)
+	if metadata == nil {
+		return nil
+	}
+	var unmet []string
+	for _, name := range metadata.Requires {
+		if os.Getenv(name) == "" {
+			unmet = append(unmet, fmt.Sprintf("%s is not set", name))
+		}
+	}
+	for _, executable := range metadata.Executables {
+		if _, err := exec.LookPath(executable); err != nil {
+			unmet = append(unmet, fmt.Sprintf("%s is not in the PATH", executable))
+		}
+	}
+	return unmet
+}
+
+// requirementsReason describes the unmet requirements of a test.
+func requirementsReason(unmet []string) string {
+	return "unmet requirements: " + strings.Join(unmet, ", ")
+}
+
+// skipOrFailTest reports the i-th test, whose requirements are not met, as
+// skipped without running it, or as failed with --strict-requirements.
+func (r *Runner) skipOrFailTest(i int, testFile string, unmet []string) testRun {
+	now := time.Now()
+	result := TestResult{
+		TestFile:          testFile,
+		StartTime:         now,
+		EndTime:           now,
+		Metadata:          r.testMetadata(testFile),
+		UnmetRequirements: unmet,
+	}
+	exitCode := unknownExitCode
+	if !r.options.StrictRequirements {
+		result.SkipReason = requirementsReason(unmet)
+		exitCode = skipTestExitCode
+	}
+	r.testEnd(i, result, exitCode)
+	return testRun{result: result, exitCode: exitCode}
+}
+
+// printUnmetRequirements lists the tests whose requirements are not met, so
+// they can all be fixed before running the tests.
+func (r *Runner) printUnmetRequirements(testFiles []string) {
+	printed := false
+	for _, testFile := range testFiles {
+		unmet := r.unmetRequirements(testFile)
+		if len(unmet) == 0 {
+			continue
+		}
+		if !printed {
+			fmt.Fprintf(r.stdout, "Unmet requirements:\n")
+			printed = true
+		}
+		fmt.Fprintf(r.stdout, "\t%s: %s\n", testFile, strings.Join(unmet, ", "))
+	}
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunCommandRequirements(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/requirements")
+	unmet := "unmet requirements: TESTBRAIN_UNSET_DOMAIN is not set, testbrain-missing-cf is not in the PATH"
+
+	tests := []struct {
+		strict         bool
+		expectedError  string
+		expectedStdout string
+	}{
+		{
+			strict:        false,
+			expectedError: "",
+			expectedStdout: "Found 2 test files\n" +
+				"SKIPPED: cf_test.sh (DURATION): " + unmet + "\n\n" +
+				"Running test met_test.sh (2/2)\n" +
+				"PASSED: met_test.sh (DURATION)\n\n" +
+				"Tests complete: 1 Passed, 1 Skipped, 0 Failed\n\n" +
+				"  Skipped tests:\n" +
+				"    cf_test.sh (DURATION): " + unmet + "\n\n",
+		},
+		{
+			strict:        true,
+			expectedError: "1 tests failed",
+			expectedStdout: "Found 2 test files\n" +
+				"FAILED: cf_test.sh (DURATION)\n\n" +
+				"Running test met_test.sh (2/2)\n" +
+				"PASSED: met_test.sh (DURATION)\n\n" +
+				"Tests complete: 1 Passed, 0 Skipped, 1 Failed\n\n" +
+				"  Failed tests:\n" +
+				"    cf_test.sh with " + unmet + " (DURATION)\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("strict=%v", tt.strict), func(t *testing.T) {
+			var stdout concurrentBuffer
+			r := setupDefaultRunner(&stdout, ioutil.Discard)
+			r.options.InOrder = true
+			r.options.StrictRequirements = tt.strict
+			r.options.TestTargets = []string{testFolder}
+			err := r.RunCommand()
+			if (err == nil && tt.expectedError != "") || (err != nil && err.Error() != tt.expectedError) {
+				t.Errorf("Expected error %q, have %v", tt.expectedError, err)
+			}
+
+			stdoutBytes, err := ioutil.ReadAll(&stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != tt.expectedStdout {
+				t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", tt.expectedStdout, stdoutStr)
+			}
+		})
+	}
+}
+
+func TestRunCommandRequirementsJSON(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/requirements")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.JSONOutput = true
+	r.options.StrictRequirements = true
+	r.options.TestTargets = []string{filepath.Join(testFolder, "cf_test.sh")}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	var results jsonResults
+	if err := json.NewDecoder(&stdout).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.FailedList) != 1 {
+		t.Fatalf("Expected 1 failed test, have %+v", results)
+	}
+	expected := []string{"TESTBRAIN_UNSET_DOMAIN is not set", "testbrain-missing-cf is not in the PATH"}
+	if failed := results.FailedList[0]; !reflect.DeepEqual(failed.UnmetRequirements, expected) {
+		t.Errorf("Expected unmet requirements %q, have %q", expected, failed.UnmetRequirements)
+	}
+}
+
+func TestRunCommandDryRunRequirements(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/requirements")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.DryRun = true
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 2 test files\n" +
+		fmt.Sprintf("Test root: %s\n", testFolder) +
+		"Test files:\n" +
+		"\tcf_test.sh (requires=TESTBRAIN_UNSET_DOMAIN,HOME executables=sh,testbrain-missing-cf)\n" +
+		"\tmet_test.sh (requires=HOME executables=sh)\n" +
+		"Unmet requirements:\n" +
+		"\tcf_test.sh: TESTBRAIN_UNSET_DOMAIN is not set, testbrain-missing-cf is not in the PATH\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// selectRerunTests keeps the tests that had one of the statuses to rerun in
+// the JSON output of an earlier run, whose test files are resolved against
+// the test root.  With RerunSameSeed, the seed of that run is used again.
+func (r *Runner) selectRerunTests(testRoot string, testFiles []string) ([]string, error) {
+	results, err := readJSONResults(r.options.RerunFrom)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the results to rerun from %s: %s", r.options.RerunFrom, err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, status := range r.options.RerunStatuses {
+		list, err := results.withStatus(status)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range list {
+			wanted[filepath.Join(testRoot, result.TestFile)] = true
+		}
+	}
+
+	var selected []string
+	for _, testFile := range testFiles {
+		path := filepath.Join(testRoot, testFile)
+		if wanted[path] {
+			selected = append(selected, testFile)
+			delete(wanted, path)
+		}
+	}
+	if len(wanted) > 0 {
+		var missing []string
+		for path := range wanted {
+			missing = append(missing, path)
+		}
+		sort.Strings(missing)
+		fmt.Fprintf(r.stderr, "Warning: %d tests to rerun were not found: %s\n", len(missing), strings.Join(missing, ", "))
+	}
+
+	if r.options.RerunSameSeed {
+		r.options.InOrder = results.InOrder
+		if !results.InOrder {
+			r.options.RandomSeed = results.Seed
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGetTestScriptsWithOrder_Rerun(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	tests := []struct {
+		statuses []string
+		expected []string
+	}{
+		{statuses: []string{"failed"}, expected: []string{"fail_test.sh"}},
+		{statuses: []string{"failed", "skipped"}, expected: []string{"fail_test.sh", "skip_test.sh"}},
+		{statuses: []string{"notRun"}, expected: nil},
+	}
+	for _, tt := range tests {
+		var stderr concurrentBuffer
+		r := setupDefaultRunner(ioutil.Discard, &stderr)
+		r.options.TestTargets = []string{testFolder}
+		r.options.RerunFrom = "../testdata/reports/mixed.json"
+		r.options.RerunStatuses = tt.statuses
+
+		_, testScripts, err := r.getTestScriptsWithOrder()
+		if err != nil {
+			t.Errorf("Error getting test scripts: %s", err)
+		}
+		sort.Strings(testScripts)
+		if !reflect.DeepEqual(tt.expected, testScripts) {
+			t.Errorf("\nExpected tests to rerun with status %v:\n%v\nHave:\n%v\n", tt.statuses, tt.expected, testScripts)
+		}
+		if r.options.RandomSeed != defaultSeed {
+			t.Errorf("Expected the seed to be kept, have %d", r.options.RandomSeed)
+		}
+
+		stderrBytes, err := ioutil.ReadAll(&stderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedStderr := ""
+		if tt.statuses[0] == "failed" {
+			expectedStderr = fmt.Sprintf("Warning: 1 tests to rerun were not found: %s\n", filepath.Join(testFolder, "gone_test.sh"))
+		}
+		if stderrStr := string(stderrBytes); stderrStr != expectedStderr {
+			t.Errorf("\nExpected stderr:\n%q\n\nHave:\n%q\n", expectedStderr, stderrStr)
+		}
+	}
+}
+
+func TestGetTestScriptsWithOrder_RerunSameSeed(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.TestTargets = []string{testFolder}
+	r.options.InOrder = true
+	r.options.RerunFrom = "../testdata/reports/mixed.json"
+	r.options.RerunStatuses = []string{"passed", "skipped"}
+	r.options.RerunSameSeed = true
+
+	if _, _, err := r.getTestScriptsWithOrder(); err != nil {
+		t.Errorf("Error getting test scripts: %s", err)
+	}
+	if r.options.InOrder || r.options.RandomSeed != 42 {
+		t.Errorf("Expected the seed 42 of the earlier run, have %d (in order: %v)", r.options.RandomSeed, r.options.InOrder)
+	}
+}
+
+func TestGetTestScriptsWithOrder_RerunErrors(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	tests := []struct {
+		rerunFrom string
+		statuses  []string
+		expected  string
+	}{
+		{
+			rerunFrom: "../testdata/reports/mixed.json",
+			statuses:  []string{"broken"},
+			expected:  `Unknown status "broken", expected one of passed, flaky, skipped, failed or notRun`,
+		},
+		{
+			rerunFrom: "../testdata/mixed/fail_test.sh",
+			statuses:  []string{"failed"},
+			expected:  "Error reading the results to rerun from ../testdata/mixed/fail_test.sh: Error parsing JSON: invalid character '#' looking for beginning of value",
+		},
+	}
+	for _, tt := range tests {
+		r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+		r.options.TestTargets = []string{testFolder}
+		r.options.RerunFrom = tt.rerunFrom
+		r.options.RerunStatuses = tt.statuses
+
+		_, _, err := r.getTestScriptsWithOrder()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("Expected error %q, have %v", tt.expected, err)
+		}
+	}
+}
+
+func TestRunCommandDryRunRerun(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.DryRun = true
+	r.options.TestTargets = []string{testFolder}
+	r.options.RerunFrom = "../testdata/reports/mixed.json"
+	r.options.RerunStatuses = []string{"failed", "skipped"}
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 2 test files\n" +
+		"Rerunning the failed, skipped tests from ../testdata/reports/mixed.json\n" +
+		fmt.Sprintf("Test root: %s\n", testFolder) +
+		"Test files:\n" +
+		"\tfail_test.sh\n" +
+		"\tskip_test.sh\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
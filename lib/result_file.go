@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// resultFileEnv is the environment variable giving the tests the path
+	// of the file where they can report more than their exit code, as JSON
+	// lines.
+	resultFileEnv = "TESTBRAIN_RESULT_FILE"
+	// skipReasonFileEnv is the environment variable giving the tests the
+	// path of the file where they can write why they were skipped.
+	skipReasonFileEnv = "TESTBRAIN_SKIP_REASON"
+
+	// Names of the files in the result directory of a test.
+	resultFileName     = "result.jsonl"
+	skipReasonFileName = "skip_reason"
+)
+
+// Types of the lines of a result file.
+const (
+	resultLineSkip    = "skip"
+	resultLineValue   = "value"
+	resultLineStep    = "step"
+	resultLineWarning = "warning"
+)
+
+// Statuses of the steps reported by tests.
+const (
+	stepPassed  = "passed"
+	stepFailed  = "failed"
+	stepSkipped = "skipped"
+)
+
+// resultLine is a line of a result file, such as
+// {"type": "step", "name": "push the app", "status": "passed"}.  Only the
+// fields relevant to its type are set.
+type resultLine struct {
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// StepResult is a named step of a test, as reported by the test.
+type StepResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// resultReport holds what a test reported in its result file.
+type resultReport struct {
+	skipReason string
+	values     map[string]string
+	steps      []StepResult
+	warnings   []string
+}
+
+// createResultDir creates the directory holding the files where a test
+// reports its results, and returns its path.
+func createResultDir() (string, error) {
+	return ioutil.TempDir("", "testbrain-")
+}
+
+// resultEnv returns the environment variables giving a test the paths of the
+// files in its result directory.  The test creates them if it needs to.
+func resultEnv(resultDir string) []string {
+	return []string{
+		fmt.Sprintf("%s=%s", resultFileEnv, filepath.Join(resultDir, resultFileName)),
+		fmt.Sprintf("%s=%s", skipReasonFileEnv, filepath.Join(resultDir, skipReasonFileName)),
+	}
+}
+
+// readResultDir reads what a test reported in the files of its result
+// directory.  A skip reason given in the result file wins over the one in
+// the skip reason file.
+func readResultDir(resultDir string) (resultReport, error) {
+	report, err := readResultFile(filepath.Join(resultDir, resultFileName))
+	if err != nil || report.skipReason != "" {
+		return report, err
+	}
+	content, err := ioutil.ReadFile(filepath.Join(resultDir, skipReasonFileName))
+	if os.IsNotExist(err) {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+	report.skipReason = lastLine(string(content))
+	return report, nil
+}
+
+// readResultFile reads what a test reported in its result file, if any.
+// Invalid lines are reported as warnings of the test.
+func readResultFile(path string) (resultReport, error) {
+	var report resultReport
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var line resultLine
+		if err := json.Unmarshal(text, &line); err != nil {
+			report.warnings = append(report.warnings, fmt.Sprintf("Invalid line %d of %s: %s", lineNumber, resultFileEnv, err))
+			continue
+		}
+		if err := report.add(line); err != nil {
+			report.warnings = append(report.warnings, fmt.Sprintf("Invalid line %d of %s: %s", lineNumber, resultFileEnv, err))
+		}
+	}
+	return report, scanner.Err()
+}
+
+// add adds a line of the result file to the report.
+func (report *resultReport) add(line resultLine) error {
+	switch line.Type {
+	case resultLineSkip:
+		report.skipReason = line.Reason
+	case resultLineValue:
+		if line.Key == "" {
+			return fmt.Errorf("Missing key")
+		}
+		if report.values == nil {
+			report.values = make(map[string]string)
+		}
+		report.values[line.Key] = line.Value
+	case resultLineStep:
+		if line.Name == "" {
+			return fmt.Errorf("Missing name")
+		}
+		if line.Status != stepPassed && line.Status != stepFailed && line.Status != stepSkipped {
+			return fmt.Errorf("Unknown step status %q, expected %s, %s or %s", line.Status, stepPassed, stepFailed, stepSkipped)
+		}
+		report.steps = append(report.steps, StepResult{Name: line.Name, Status: line.Status})
+	case resultLineWarning:
+		report.warnings = append(report.warnings, line.Message)
+	default:
+		return fmt.Errorf("Unknown type %q, expected %s, %s, %s or %s", line.Type, resultLineSkip, resultLineValue, resultLineStep, resultLineWarning)
+	}
+	return nil
+}
+
+// apply records the report in the result of the test.  The skip reason is
+// only kept when the test was skipped; when the test gave none, the last line
+// of its output is used instead.
+func (report resultReport) apply(result *TestResult, exitCode int) {
+	if exitCode == skipTestExitCode {
+		result.SkipReason = report.skipReason
+		if result.SkipReason == "" {
+			result.SkipReason = lastLine(result.Output)
+		}
+	}
+	result.Values = report.values
+	result.Steps = report.steps
+	result.Warnings = report.warnings
+}
+
+// hasReport tells whether the test reported anything besides its exit code.
+func (result TestResult) hasReport() bool {
+	return len(result.Values) > 0 || len(result.Steps) > 0 || len(result.Warnings) > 0 || len(result.Artifacts) > 0
+}
+
+// reportLines returns the lines describing what the test reported.
+func (result TestResult) reportLines() []string {
+	var lines []string
+	var keys []string
+	for key := range result.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %s", key, result.Values[key]))
+	}
+	for _, step := range result.Steps {
+		var status string
+		switch step.Status {
+		case stepPassed:
+			status = green(step.Status)
+		case stepFailed:
+			status = red(step.Status)
+		default:
+			status = yellowBold(step.Status)
+		}
+		lines = append(lines, fmt.Sprintf("step %s: %s", step.Name, status))
+	}
+	for _, warning := range result.Warnings {
+		lines = append(lines, fmt.Sprintf("%s: %s", yellowBold("warning"), warning))
+	}
+	for _, path := range result.artifactPaths() {
+		lines = append(lines, fmt.Sprintf("artifact: %s", path))
+	}
+	return lines
+}
+
+// lastLine returns the last line of a text that is not blank, trimmed.
+func lastLine(text string) string {
+	lines := strings.Split(text, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// removeResultDir removes the result directory of a test once it was read.
+func (r *Runner) removeResultDir(resultDir string) {
+	if err := os.RemoveAll(resultDir); err != nil {
+		fmt.Fprintf(r.stderr, "Error removing result directory: %v\n", err)
+	}
+}
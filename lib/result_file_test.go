@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadResultFile(t *testing.T) {
+	t.Parallel()
+
+	resultDir, err := createResultDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resultDir)
+	resultFile := filepath.Join(resultDir, resultFileName)
+	content := `{"type": "skip", "reason": "No CF_DOMAIN"}
+{"type": "value", "key": "region", "value": "eu-west"}
+
+{"type": "value", "key": "region", "value": "us-east"}
+{"type": "step", "name": "log in", "status": "passed"}
+{"type": "step", "name": "push", "status": "broken"}
+{"type": "warning", "message": "The API was slow"}
+{"type": "value", "value": "no key"}
+{"type": "metric"}
+not JSON
+`
+	if err := ioutil.WriteFile(resultFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := readResultFile(resultFile)
+	if err != nil {
+		t.Fatalf("Error reading result file: %s", err)
+	}
+	expected := resultReport{
+		skipReason: "No CF_DOMAIN",
+		values:     map[string]string{"region": "us-east"},
+		steps:      []StepResult{{Name: "log in", Status: stepPassed}},
+		warnings: []string{
+			`Invalid line 6 of TESTBRAIN_RESULT_FILE: Unknown step status "broken", expected passed, failed or skipped`,
+			"The API was slow",
+			"Invalid line 8 of TESTBRAIN_RESULT_FILE: Missing key",
+			`Invalid line 9 of TESTBRAIN_RESULT_FILE: Unknown type "metric", expected skip, value, step or warning`,
+			"Invalid line 10 of TESTBRAIN_RESULT_FILE: invalid character 'o' in literal null (expecting 'u')",
+		},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("\nExpected report:\n%#v\nHave:\n%#v\n", expected, report)
+	}
+
+	var result TestResult
+	report.apply(&result, 0)
+	if result.SkipReason != "" {
+		t.Errorf("Expected the skip reason of a test that passed to be left out, have %q", result.SkipReason)
+	}
+}
+
+func TestRunCommandResultFile(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/results")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 3 test files\n" +
+		"Running test report_test.sh (1/3)\n" +
+		"FAILED: report_test.sh (DURATION)\n\n" +
+		"Test output:\n" +
+		"Running test silent_test.sh (2/3)\n" +
+		"PASSED: silent_test.sh (DURATION)\n\n" +
+		"Running test skip_test.sh (3/3)\n" +
+		"SKIPPED: skip_test.sh (DURATION): No Docker registry available\n\n" +
+		"Tests complete: 1 Passed, 1 Skipped, 1 Failed\n\n" +
+		"  Skipped tests:\n" +
+		"    skip_test.sh (DURATION): No Docker registry available\n\n" +
+		"  Failed tests:\n" +
+		"    report_test.sh with exit code 1 (DURATION)\n\n" +
+		"  Test reports:\n" +
+		"    report_test.sh\n" +
+		"      region: eu-west\n" +
+		"      step log in: passed\n" +
+		"      step push the app: failed\n" +
+		"      warning: The API was slow\n" +
+		"      warning: Invalid line 5 of TESTBRAIN_RESULT_FILE: invalid character 'o' in literal null (expecting 'u')\n\n"
+	if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestRunCommandResultFileJSON(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/results")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.JSONOutput = true
+	r.options.TestTargets = []string{filepath.Join(testFolder, "report_test.sh")}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	var results jsonResults
+	if err := json.NewDecoder(&stdout).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.FailedList) != 1 {
+		t.Fatalf("Expected 1 failed test, have %+v", results)
+	}
+	failed := results.FailedList[0]
+	expectedValues := map[string]string{"region": "eu-west"}
+	expectedSteps := []StepResult{
+		{Name: "log in", Status: stepPassed},
+		{Name: "push the app", Status: stepFailed},
+	}
+	if !reflect.DeepEqual(failed.Values, expectedValues) || !reflect.DeepEqual(failed.Steps, expectedSteps) || len(failed.Warnings) != 2 {
+		t.Errorf("Expected values %v, steps %v and 2 warnings, have %v, %v and %v", expectedValues, expectedSteps, failed.Values, failed.Steps, failed.Warnings)
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{text: "", expected: ""},
+		{text: "only line", expected: "only line"},
+		{text: "first\n  second  \n\n \n", expected: "second"},
+	}
+	for _, tt := range tests {
+		if line := lastLine(tt.text); line != tt.expected {
+			t.Errorf("Expected the last line of %q to be %q, have %q", tt.text, tt.expected, line)
+		}
+	}
+}
+
+func TestRunCommandSkipReasons(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/skipped")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 3 test files\n" +
+		"Running test output_test.sh (1/3)\n" +
+		"SKIPPED: output_test.sh (DURATION): No quota left for the test\n\n" +
+		"Running test reason_file_test.sh (2/3)\n" +
+		"SKIPPED: reason_file_test.sh (DURATION): Only runs on SLE stemcells\n\n" +
+		"SKIPPED: requires_test.sh (DURATION): unmet requirements: TESTBRAIN_UNSET_DOMAIN is not set, TESTBRAIN_UNSET_USER is not set\n\n" +
+		"Tests complete: 0 Passed, 3 Skipped, 0 Failed\n\n" +
+		"  Skipped tests:\n" +
+		"    output_test.sh (DURATION): No quota left for the test\n" +
+		"    reason_file_test.sh (DURATION): Only runs on SLE stemcells\n" +
+		"    requires_test.sh (DURATION): unmet requirements: TESTBRAIN_UNSET_DOMAIN is not set, TESTBRAIN_UNSET_USER is not set\n\n"
+	if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
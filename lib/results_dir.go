@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// artifactsDirEnv is the environment variable giving the tests the path
+	// of the directory where they can leave files to keep along with their
+	// logs, when a results directory is given.
+	artifactsDirEnv = "TESTBRAIN_ARTIFACTS_DIR"
+
+	// Names of the files in the directory of a test in the results
+	// directory.
+	stdoutLogName    = "stdout.log"
+	stderrLogName    = "stderr.log"
+	outputLogName    = "output.log"
+	artifactsDirName = "artifacts"
+
+	// resultsJSONName is the name of the file in the results directory
+	// holding the results of the run, as in the JSON output.
+	resultsJSONName = "results.json"
+)
+
+// testResultsDir returns the directory of a test in the results directory,
+// which is named after the path of the test.
+func (r *Runner) testResultsDir(testFile string) string {
+	return filepath.Join(r.options.ResultsDir, testFile)
+}
+
+// artifactsDir returns the absolute path of the artifacts directory of a test,
+// so that the test can use it wherever it runs.
+func (r *Runner) artifactsDir(testFile string) (string, error) {
+	return filepath.Abs(filepath.Join(r.testResultsDir(testFile), artifactsDirName))
+}
+
+// testLogs are the log files of an attempt at running a test.
+type testLogs struct {
+	dir string
+	// artifactsDir is the absolute path of the artifacts directory.
+	artifactsDir string
+	stdout       *os.File
+	stderr       *os.File
+	output       *os.File
+}
+
+// createTestLogs creates the directory of a test in the results directory,
+// with its artifacts directory and its log files.
+func (r *Runner) createTestLogs(testFile string) (*testLogs, error) {
+	logs := &testLogs{dir: r.testResultsDir(testFile)}
+	var err error
+	if logs.artifactsDir, err = r.artifactsDir(testFile); err != nil {
+		return nil, err
+	}
+	// What an earlier run left is not reported as part of this one.
+	if err := removeResultsDir(logs.dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(logs.artifactsDir, 0755); err != nil {
+		return nil, err
+	}
+	if logs.stdout, err = os.Create(filepath.Join(logs.dir, stdoutLogName)); err != nil {
+		logs.close()
+		return nil, err
+	}
+	if logs.stderr, err = os.Create(filepath.Join(logs.dir, stderrLogName)); err != nil {
+		logs.close()
+		return nil, err
+	}
+	if logs.output, err = os.Create(filepath.Join(logs.dir, outputLogName)); err != nil {
+		logs.close()
+		return nil, err
+	}
+	return logs, nil
+}
+
+// writers returns writers copying the stdout and stderr of a test to the
+// given writers, and to the logs.  They are used by separate goroutines.
+func (logs *testLogs) writers(stdout io.Writer, stderr io.Writer) (io.Writer, io.Writer) {
+	output := &lockedWriter{writer: logs.output}
+	return io.MultiWriter(stdout, logs.stdout, output), io.MultiWriter(stderr, logs.stderr, output)
+}
+
+func (logs *testLogs) close() {
+	for _, file := range []*os.File{logs.stdout, logs.stderr, logs.output} {
+		if file != nil {
+			file.Close()
+		}
+	}
+}
+
+// listArtifacts returns the files left in the artifacts directory of a test,
+// relative to the directory of the test.
+func listArtifacts(testDir string) []string {
+	var artifacts []string
+	filepath.Walk(filepath.Join(testDir, artifactsDirName), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if relPath, err := filepath.Rel(testDir, path); err == nil {
+			artifacts = append(artifacts, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	return artifacts
+}
+
+// removeTestResults removes the directory of a test in the results directory,
+// and the ones of its failed attempts, left by an earlier run.
+func (r *Runner) removeTestResults(testFile string) error {
+	testDir := r.testResultsDir(testFile)
+	if err := removeResultsDir(testDir); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(testDir))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	attemptPrefix := filepath.Base(testDir) + ".attempt-"
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), attemptPrefix) {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), attemptPrefix)); err != nil {
+			continue
+		}
+		if err := removeResultsDir(filepath.Join(filepath.Dir(testDir), entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeResultsDir removes a directory of the results directory, if it exists.
+// Anything else is left alone, such as the test itself when the results
+// directory is the test root.
+func removeResultsDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return os.RemoveAll(dir)
+}
+
+// keepFailedAttempt moves the logs and artifacts of a failed attempt at
+// running a test aside before it is retried, next to the directory of the
+// test, and returns where they are.
+func (r *Runner) keepFailedAttempt(testFile string, attempt int) (string, error) {
+	testDir := r.testResultsDir(testFile)
+	attemptDir := fmt.Sprintf("%s.attempt-%d", testDir, attempt)
+	if err := os.RemoveAll(attemptDir); err != nil {
+		return testDir, err
+	}
+	if err := os.Rename(testDir, attemptDir); err != nil {
+		return testDir, err
+	}
+	return attemptDir, nil
+}
+
+// artifactPaths returns the paths of the artifacts of a test.
+func (result TestResult) artifactPaths() []string {
+	var paths []string
+	for _, artifact := range result.Artifacts {
+		paths = append(paths, filepath.Join(result.ResultsDir, filepath.FromSlash(artifact)))
+	}
+	return paths
+}
+
+// resultsDirReporter writes the results of a run to the results directory
+// once it is done.  The logs of the tests are written as they run.
+type resultsDirReporter struct {
+	runner *Runner
+}
+
+func (reporter resultsDirReporter) RunStart(RunInfo)          {}
+func (reporter resultsDirReporter) TestStart(int, string)     {}
+func (reporter resultsDirReporter) TestOutput(string, []byte) {}
+func (reporter resultsDirReporter) TestEnd(FinishedTest)      {}
+func (reporter resultsDirReporter) RunEnd(results Results) error {
+	if err := reporter.runner.writeResultsJSON(results); err != nil {
+		return fmt.Errorf("Error writing results to the results directory: %s", err)
+	}
+	return nil
+}
+
+// writeResultsJSON writes the results, as in the JSON output, to the results
+// directory.
+func (r *Runner) writeResultsJSON(results Results) error {
+	if err := os.MkdirAll(r.options.ResultsDir, 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(filepath.Join(r.options.ResultsDir, resultsJSONName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(r.jsonResults(results)); err != nil {
+		return err
+	}
+	return file.Close()
+}
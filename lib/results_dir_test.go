@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandResultsDir(t *testing.T) {
+	t.Parallel()
+
+	resultsDir, err := ioutil.TempDir("", "testbrain-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resultsDir)
+
+	mixedFolder, _ := filepath.Abs("../testdata/mixed")
+	artifactsFolder, _ := filepath.Abs("../testdata/artifacts")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.Retries = 1
+	r.options.ResultsDir = resultsDir
+	r.options.TestTargets = []string{artifactsFolder, mixedFolder}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	expectedFiles := map[string]string{
+		"artifacts/artifact_test.sh/output.log":               "Taking a screenshot\n",
+		"artifacts/artifact_test.sh/artifacts/screenshot.txt": "Pretend this is a screenshot\n",
+		"artifacts/artifact_test.sh/artifacts/cf/app.log":     "Pretend these are the logs of the app\n",
+		"mixed/fail_test.sh/output.log":                       "Goodbye World!\n",
+		"mixed/fail_test.sh.attempt-1/output.log":             "Goodbye World!\n",
+		"mixed/skip_test.sh/stdout.log":                       "Something stdout\n",
+		"mixed/skip_test.sh/stderr.log":                       "Something stderr\n",
+		"mixed/success_test.sh/stdout.log":                    "Hello World!\n",
+		"mixed/success_test.sh/stderr.log":                    "",
+	}
+	for file, expected := range expectedFiles {
+		content, err := ioutil.ReadFile(filepath.Join(resultsDir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Errorf("Error reading %s: %s", file, err)
+		} else if string(content) != expected {
+			t.Errorf("Expected %s to contain %q, have %q", file, expected, content)
+		}
+	}
+	// Stdout and stderr are copied separately, so their order in the
+	// combined log is not known.
+	skipOutput, err := ioutil.ReadFile(filepath.Join(resultsDir, "mixed", "skip_test.sh", outputLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipOutput) != len("Something stdout\nSomething stderr\n") ||
+		!strings.Contains(string(skipOutput), "Something stdout\n") || !strings.Contains(string(skipOutput), "Something stderr\n") {
+		t.Errorf("Expected the combined log of skip_test.sh to have its stdout and stderr, have %q", skipOutput)
+	}
+
+	resultsFile, err := os.Open(filepath.Join(resultsDir, resultsJSONName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resultsFile.Close()
+	var results jsonResults
+	if err := json.NewDecoder(resultsFile).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.PassedList) != 2 || len(results.FailedList) != 1 || len(results.SkippedList) != 1 {
+		t.Fatalf("Expected 2 passed, 1 failed and 1 skipped tests in %s, have %+v", resultsJSONName, results)
+	}
+	passed := results.PassedList[0]
+	expectedArtifacts := []string{"artifacts/cf/app.log", "artifacts/screenshot.txt"}
+	if passed.ResultsDir != filepath.Join(resultsDir, "artifacts", "artifact_test.sh") || !reflect.DeepEqual(passed.Artifacts, expectedArtifacts) {
+		t.Errorf("Expected the results directory and the artifacts of artifact_test.sh, have %q and %q", passed.ResultsDir, passed.Artifacts)
+	}
+	failed := results.FailedList[0]
+	if attemptDir := filepath.Join(resultsDir, "mixed", "fail_test.sh.attempt-1"); len(failed.FailedAttempts) != 1 || failed.FailedAttempts[0].ResultsDir != attemptDir {
+		t.Errorf("Expected the failed attempt of fail_test.sh to be kept in %s, have %+v", attemptDir, failed.FailedAttempts)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedReport := "  Test reports:\n" +
+		"    artifacts/artifact_test.sh\n" +
+		"      artifact: " + filepath.Join(resultsDir, "artifacts", "artifact_test.sh", "artifacts", "cf", "app.log") + "\n" +
+		"      artifact: " + filepath.Join(resultsDir, "artifacts", "artifact_test.sh", "artifacts", "screenshot.txt") + "\n\n"
+	if !strings.Contains(string(stdoutBytes), expectedReport) {
+		t.Errorf("Expected the artifacts in the text output:\n%s\nHave:\n%s", expectedReport, stdoutBytes)
+	}
+}
+
+func TestRunAttemptResultsDirError(t *testing.T) {
+	t.Parallel()
+
+	testFolder, err := ioutil.TempDir("", "testbrain-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testFolder)
+	script := "#!/bin/sh\necho \"Artifacts: ${" + artifactsDirEnv + "-unset}\"\n"
+	if err := ioutil.WriteFile(filepath.Join(testFolder, "a_test.sh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(ioutil.Discard, &stderr)
+	// The results directory cannot be created below a file.
+	r.options.ResultsDir = filepath.Join(testFolder, "a_test.sh", "results")
+	result, exitCode := r.runAttempt("a_test.sh", testFolder, r.interrupted)
+	if exitCode != 0 {
+		t.Errorf("Expected the test to pass, have exit code %d", exitCode)
+	}
+	// The test is not given an artifacts directory that does not exist.
+	if expected := "Artifacts: unset\n"; result.Output != expected {
+		t.Errorf("\nExpected output:\n%q\nHave:\n%q\n", expected, result.Output)
+	}
+	if result.ResultsDir != "" {
+		t.Errorf("Expected no results directory, have %s", result.ResultsDir)
+	}
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(stderrBytes), "Error creating the logs of the test: ") {
+		t.Errorf("Expected an error creating the logs, have %q", stderrBytes)
+	}
+}
+
+func TestRunCommandResultsDirTwice(t *testing.T) {
+	t.Parallel()
+
+	testFolder, err := ioutil.TempDir("", "testbrain-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testFolder)
+	resultsDir, err := ioutil.TempDir("", "testbrain-results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(resultsDir)
+	runTest := func(script string) jsonResults {
+		if err := ioutil.WriteFile(filepath.Join(testFolder, "a_test.sh"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+		r.options.Retries = 1
+		r.options.ResultsDir = resultsDir
+		r.options.TestTargets = []string{testFolder}
+		r.RunCommand()
+		results, err := readJSONResults(filepath.Join(resultsDir, resultsJSONName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	// The first run leaves an artifact, and the logs of a failed attempt.
+	results := runTest(`echo old > "$TESTBRAIN_ARTIFACTS_DIR/old.txt"; exit 1`)
+	if len(results.FailedList) != 1 || !reflect.DeepEqual(results.FailedList[0].Artifacts, []string{"artifacts/old.txt"}) {
+		t.Fatalf("Expected a failed test with an artifact, have %+v", results.FailedList)
+	}
+	results = runTest("exit 0")
+	if len(results.PassedList) != 1 || len(results.PassedList[0].Artifacts) != 0 {
+		t.Errorf("Expected a passed test without artifacts, have %+v", results.PassedList)
+	}
+	for _, path := range []string{"a_test.sh/artifacts/old.txt", "a_test.sh.attempt-1"} {
+		if _, err := os.Stat(filepath.Join(resultsDir, filepath.FromSlash(path))); !os.IsNotExist(err) {
+			t.Errorf("Expected %s of the earlier run to be removed, have %v", path, err)
+		}
+	}
+
+	// The tests are not removed when they are in the results directory.
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(ioutil.Discard, &stderr)
+	r.options.ResultsDir = testFolder
+	r.options.TestTargets = []string{testFolder}
+	r.RunCommand()
+	if _, err := os.Stat(filepath.Join(testFolder, "a_test.sh")); err != nil {
+		t.Errorf("Expected the test to be kept, have %v", err)
+	}
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(stderrBytes), "a_test.sh is not a directory") {
+		t.Errorf("Expected an error about the directory of the test, have %q", stderrBytes)
+	}
+}
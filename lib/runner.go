@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -20,6 +22,19 @@ import (
 const (
 	unknownExitCode  = -1
 	skipTestExitCode = 99
+
+	// interruptedExitCode is used internally for tests that were terminated
+	// because the run was interrupted.
+	interruptedExitCode = -2
+
+	// failedSubTestsExitCode is used internally for tests that exited with
+	// 0, but reported sub-tests that failed.
+	failedSubTestsExitCode = -3
+
+	// killWaitTimeout is how long to wait for a test to go away after it has
+	// been sent SIGKILL, in case some process escaped its process group and
+	// is still holding on to its output.
+	killWaitTimeout = 5 * time.Second
 )
 
 var (
@@ -32,15 +47,55 @@ var (
 
 // RunnerOptions represents options passed to the Runner.
 type RunnerOptions struct {
-	TestTargets  []string
-	IncludeReStr string
-	ExcludeReStr string
-	Timeout      time.Duration
-	InOrder      bool
-	RandomSeed   int64
-	JSONOutput   bool
-	Verbose      bool
-	DryRun       bool
+	TestTargets      []string
+	IncludeReStr     string
+	ExcludeReStr     string
+	TagsExpr         string
+	Timeout          time.Duration
+	InOrder          bool
+	RandomSeed       int64
+	JSONOutput       bool
+	JSONStreamOutput bool
+	TAPOutput        bool
+	ParseTAP         bool
+	Verbose          bool
+	DryRun           bool
+	JUnitFile        string
+	HTMLFile         string
+	MarkdownFile     string
+	Parallelism      int
+	KillGracePeriod  time.Duration
+	Retries          int
+	FailOnFlaky      bool
+	SetupScript      string
+	TeardownScript   string
+	// StepSummaryFile is the GitHub Actions job summary the Markdown summary
+	// is appended to, if any.
+	StepSummaryFile string
+	// CIAnnotations is the CI system whose annotations are written along with
+	// the output, CIAnnotationsGitHub or CIAnnotationsTeamCity, if any.
+	CIAnnotations string
+	// ResultsDir is the directory where the logs and the artifacts of each
+	// test, and the results of the run, are written, if any.
+	ResultsDir string
+	// StrictRequirements fails the tests whose requirements are not met,
+	// instead of skipping them.
+	StrictRequirements bool
+	// ShardIndex and ShardTotal split the tests between several runs; this
+	// run only runs the tests of the ShardIndex-th shard (counting from 0) out
+	// of ShardTotal.  The tests are not split when ShardTotal is 0 or 1.
+	ShardIndex int
+	ShardTotal int
+	// BalanceFrom is the JSON output of an earlier run, whose durations are
+	// used to split the tests into shards that take about as long.
+	BalanceFrom string
+	// RerunFrom is the JSON output of an earlier run; only the tests with
+	// one of the RerunStatuses in it are run.  The seed of that run is used
+	// again if RerunSameSeed is set, though the order of the tests differs
+	// from that run, as the seed shuffles fewer tests.
+	RerunFrom     string
+	RerunStatuses []string
+	RerunSameSeed bool
 }
 
 // Runner runs a series of tests and displays its results.
@@ -49,6 +104,32 @@ type Runner struct {
 	stdout io.Writer
 
 	options RunnerOptions
+
+	// reporters are told about the progress of the current run, and
+	// addedReporters are the ones added with AddReporter.
+	reporters      []Reporter
+	addedReporters []Reporter
+	// roundTests is the number of tests run by the current call to runTests,
+	// and concurrent is set while they run concurrently.
+	roundTests int
+	concurrent bool
+	// metadata holds the metadata of the test scripts found, by their path
+	// relative to the test root.  Scripts without metadata are left out.
+	metadata map[string]TestMetadata
+	// filteredTests are the test scripts that were found but filtered out.
+	filteredTests []FilteredTest
+	// discoveredTests is the number of tests found, across all the shards.
+	discoveredTests int
+	// shardEstimate is how long the tests of the shard should take, when the
+	// shards are balanced using earlier durations.
+	shardEstimate time.Duration
+
+	// interrupted is closed when the run is interrupted.
+	interrupted   chan struct{}
+	interruptOnce sync.Once
+	// running holds the commands of the tests currently running.
+	running     map[*exec.Cmd]struct{}
+	runningLock sync.Mutex
 }
 
 // NewRunner constructs a new Runner.
@@ -58,9 +139,11 @@ func NewRunner(
 	options RunnerOptions,
 ) *Runner {
 	return &Runner{
-		stdout:  stdout,
-		stderr:  stderr,
-		options: options,
+		stdout:      stdout,
+		stderr:      stderr,
+		options:     options,
+		interrupted: make(chan struct{}),
+		running:     make(map[*exec.Cmd]struct{}),
 	}
 }
 
@@ -69,35 +152,58 @@ func NewRunner(
 func (r *Runner) RunCommand() error {
 	testRoot, testFiles, err := r.getTestScriptsWithOrder()
 	if err != nil {
+		fmt.Fprintln(r.stderr, redBold(err.Error()))
 		return err
 	}
-	if !r.options.JSONOutput {
-		fmt.Fprintf(r.stdout, "Found %d test files\n", len(testFiles))
-		if !r.options.InOrder {
-			fmt.Fprintf(r.stdout, "Using seed: %d\n", r.options.RandomSeed)
-		}
-	}
+	r.setupReporters()
+	r.runStart(testRoot, testFiles)
 	if r.options.DryRun {
-		if !r.options.JSONOutput {
-			fmt.Fprintf(r.stdout, "Test root: %s\n", testRoot)
-			fmt.Fprintf(r.stdout, "Test files:\n")
-			for _, testFile := range testFiles {
-				fmt.Fprintf(r.stdout, "\t%s\n", testFile)
-			}
-		}
+		r.dryRun(testRoot, testFiles)
 		return nil
 	}
 
-	passedResults, skippedResults, failedResults := r.runAllTests(testFiles, testRoot)
-	if r.options.JSONOutput {
-		r.outputResultsJSON(passedResults, skippedResults, failedResults)
-	} else {
-		r.outputResults(passedResults, skippedResults, failedResults)
+	results := r.runAllTests(testFiles, testRoot)
+	if err := r.runEnd(results); err != nil {
+		return err
 	}
-	if len(failedResults) == 0 {
-		return nil
+	if results.Interrupted {
+		return ErrInterrupted
+	}
+	if len(results.Failed) > 0 {
+		return fmt.Errorf("%d tests failed", len(results.Failed))
+	}
+	if failedHooks := results.failedHooks(); failedHooks > 0 {
+		return fmt.Errorf("%d hooks failed", failedHooks)
 	}
-	return fmt.Errorf("%d tests failed", len(failedResults))
+	if r.options.FailOnFlaky && len(results.Flaky) > 0 {
+		return fmt.Errorf("%d tests were flaky", len(results.Flaky))
+	}
+	return nil
+}
+
+// printSelection prints how many tests were selected, and how.
+func (r *Runner) printSelection(testFiles []string) {
+	fmt.Fprintf(r.stdout, "Found %d test files\n", len(testFiles))
+	if !r.options.InOrder {
+		fmt.Fprintf(r.stdout, "Using seed: %d\n", r.options.RandomSeed)
+	}
+	if r.options.RerunFrom != "" {
+		fmt.Fprintf(r.stdout, "Rerunning the %s tests from %s\n", strings.Join(r.options.RerunStatuses, ", "), r.options.RerunFrom)
+	}
+	if shard := r.shardInfo(); shard != nil {
+		if shard.BalanceFrom != "" {
+			fmt.Fprintf(r.stdout, "Using shard: %d of %d (%d of %d test files, estimated %v from %s)\n",
+				shard.Index, shard.Total, len(testFiles), shard.DiscoveredTests, formatDuration(shard.EstimatedDuration), shard.BalanceFrom)
+		} else {
+			fmt.Fprintf(r.stdout, "Using shard: %d of %d (%d of %d test files)\n", shard.Index, shard.Total, len(testFiles), shard.DiscoveredTests)
+		}
+	}
+}
+
+// textOutput tells whether the progress and results are output as text,
+// rather than as JSON or TAP.
+func (r *Runner) textOutput() bool {
+	return !r.options.JSONOutput && !r.options.JSONStreamOutput && !r.options.TAPOutput
 }
 
 func (r *Runner) getTestScriptsWithOrder() (string, []string, error) {
@@ -105,7 +211,18 @@ func (r *Runner) getTestScriptsWithOrder() (string, []string, error) {
 	if err != nil {
 		return "", nil, err
 	}
+	if r.options.RerunFrom != "" {
+		testFiles, err = r.selectRerunTests(testRoot, testFiles)
+		if err != nil {
+			return "", nil, err
+		}
+	}
 	sort.Strings(testFiles)
+	r.discoveredTests = len(testFiles)
+	testFiles, err = r.shard(testFiles)
+	if err != nil {
+		return "", nil, err
+	}
 	if !r.options.InOrder {
 		r.shuffleOrder(testFiles)
 	}
@@ -121,8 +238,47 @@ func (r *Runner) getTestScripts() (string, []string, error) {
 	if err != nil {
 		return "", nil, fmt.Errorf("Error parsing files to exclude: %s", err)
 	}
+	var tagExpr tagExpression
+	if r.options.TagsExpr != "" {
+		tagExpr, err = parseTagExpression(r.options.TagsExpr)
+		if err != nil {
+			return "", nil, fmt.Errorf("Error parsing tags expression %q: %s", r.options.TagsExpr, err)
+		}
+	}
 
 	var foundTests []string
+	foundMetadata := make(map[string]TestMetadata)
+	var filteredTests []FilteredTest
+	considerTest := func(path string) error {
+		if !includeRe.MatchString(path) || r.isHookScript(path) {
+			return nil
+		}
+		if excludeRe.MatchString(path) {
+			filteredTests = append(filteredTests, FilteredTest{
+				TestFile: path,
+				Reason:   fmt.Sprintf("path matches --exclude %q", r.options.ExcludeReStr),
+			})
+			return nil
+		}
+		metadata, warnings, err := readTestMetadata(path)
+		if err != nil {
+			return fmt.Errorf("Error reading metadata of %s: %s", path, err)
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(r.stderr, "Warning: %s\n", warning)
+		}
+		if tagExpr != nil && !matchTags(tagExpr, metadata.Tags) {
+			filteredTests = append(filteredTests, FilteredTest{
+				TestFile: path,
+				Reason:   fmt.Sprintf("tags [%s] do not match --tags %q", strings.Join(metadata.Tags, ","), r.options.TagsExpr),
+			})
+			return nil
+		}
+		foundTests = append(foundTests, path)
+		foundMetadata[path] = metadata
+		return nil
+	}
+
 	for _, testFolderOriginal := range r.options.TestTargets {
 		testFolder, err := filepath.Abs(testFolderOriginal)
 		if err != nil {
@@ -134,13 +290,9 @@ func (r *Runner) getTestScripts() (string, []string, error) {
 		}
 		if !info.IsDir() {
 			// This is an individual test
-			if !includeRe.MatchString(testFolder) {
-				continue
+			if err := considerTest(testFolder); err != nil {
+				return "", nil, err
 			}
-			if excludeRe.MatchString(testFolder) {
-				continue
-			}
-			foundTests = append(foundTests, testFolder)
 			continue
 		}
 		err = filepath.Walk(testFolder, func(path string, info os.FileInfo, err error) error {
@@ -150,14 +302,7 @@ func (r *Runner) getTestScripts() (string, []string, error) {
 			if info.IsDir() {
 				return nil
 			}
-			if !includeRe.MatchString(path) {
-				return nil
-			}
-			if excludeRe.MatchString(path) {
-				return nil
-			}
-			foundTests = append(foundTests, path)
-			return nil
+			return considerTest(path)
 		})
 		if err != nil {
 			return "", nil, err
@@ -194,16 +339,55 @@ func (r *Runner) getTestScripts() (string, []string, error) {
 			commonPrefix = filepath.Dir(testFolder)
 		}
 	}
+	r.metadata = make(map[string]TestMetadata)
 	for i, path := range foundTests {
 		relPath, err := filepath.Rel(commonPrefix, path)
 		if err != nil {
 			return "", nil, fmt.Errorf("Error finding relative path of %s from %s: %s", path, commonPrefix, err)
 		}
 		foundTests[i] = relPath
+		if metadata := foundMetadata[path]; !metadata.IsEmpty() {
+			r.metadata[relPath] = metadata
+		}
+	}
+	for i, filteredTest := range filteredTests {
+		// The test root is based on the tests found only, so the filtered
+		// tests may not be below it; keep their absolute path then.
+		relPath, err := filepath.Rel(commonPrefix, filteredTest.TestFile)
+		if err == nil && !strings.HasPrefix(relPath, "..") {
+			filteredTests[i].TestFile = relPath
+		}
 	}
+	sort.Slice(filteredTests, func(i, j int) bool {
+		return filteredTests[i].TestFile < filteredTests[j].TestFile
+	})
+	r.filteredTests = filteredTests
 	return commonPrefix, foundTests, nil
 }
 
+// testMetadata returns the metadata of a test, or nil if it has none.
+func (r *Runner) testMetadata(testFile string) *TestMetadata {
+	metadata, ok := r.metadata[testFile]
+	if !ok {
+		return nil
+	}
+	return &metadata
+}
+
+// testTimeout returns the timeout of a test, which its metadata may override.
+func (r *Runner) testTimeout(testFile string) time.Duration {
+	if metadata := r.testMetadata(testFile); metadata != nil && metadata.Timeout > 0 {
+		return metadata.Timeout
+	}
+	return r.options.Timeout
+}
+
+// FilteredTest is a test script that was found, but filtered out.
+type FilteredTest struct {
+	TestFile string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
 func (r *Runner) shuffleOrder(list []string) {
 	rand.Seed(r.options.RandomSeed)
 	// See https://en.wikipedia.org/wiki/Fisher–Yates_shuffle#The_modern_algorithm.
@@ -213,82 +397,267 @@ func (r *Runner) shuffleOrder(list []string) {
 	}
 }
 
-func (r *Runner) runAllTests(testFiles []string, testFolder string) ([]PassedResult, []SkippedResult, []FailedResult) {
-	passedResults := make([]PassedResult, 0)
-	skippedResults := make([]SkippedResult, 0)
-	failedResults := make([]FailedResult, 0)
-	for i, testFile := range testFiles {
-		if !r.options.JSONOutput {
-			fmt.Fprintf(r.stdout, "Running test %s (%d/%d)\n", testFile, i+1, len(testFiles))
-		}
+func (r *Runner) runAllTests(testFiles []string, testFolder string) Results {
+	runs, hookResults := r.runTests(testFiles, testFolder)
+	results := newResults(hookResults, r.isInterrupted())
+	for i, run := range runs {
+		results.add(testFiles[i], run)
+	}
+	return results
+}
 
-		var cmdStdout, cmdStderr io.Writer
-		var outputBuf bytes.Buffer
-		if r.options.Verbose {
-			cmdStdout = r.stdout
-			cmdStderr = r.stderr
-		} else {
-			cmdStdout = &outputBuf
-			cmdStderr = &outputBuf
-		}
-		exitCode := r.runSingleTest(testFile, testFolder, cmdStdout, cmdStderr)
-
-		if exitCode == skipTestExitCode {
-			result := SkippedResult{testFile}
-			skippedResults = append(skippedResults, result)
-			fmt.Fprintln(r.stdout, result)
-		} else if exitCode == 0 {
-			result := PassedResult{testFile}
-			passedResults = append(passedResults, result)
-			fmt.Fprintln(r.stdout, result)
-		} else {
-			result := FailedResult{
-				TestResult{testFile},
-				exitCode,
+// runTests runs the tests in testFiles along with their hooks, and returns
+// their runs in the same order, and the results of the hooks.
+func (r *Runner) runTests(testFiles []string, testFolder string) ([]testRun, []HookResult) {
+	parallelism := r.options.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > len(testFiles) {
+		parallelism = len(testFiles)
+	}
+
+	// Tests are dispatched in the (possibly shuffled) order of testFiles; the
+	// runs are stored by index so the results keep that order no matter
+	// which worker finishes first.
+	runs := make([]testRun, len(testFiles))
+	hooks := r.findHooks(testFolder, testFiles)
+	indices := make(chan int)
+	r.roundTests = len(testFiles)
+	r.concurrent = parallelism > 1
+	var wg sync.WaitGroup
+	for worker := 0; worker < parallelism; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				run := r.runTestWithHooks(hooks, i, testFiles, testFolder)
+				run.started = true
+				runs[i] = run
 			}
-			failedResults = append(failedResults, result)
-			fmt.Fprintln(r.stdout, result)
-			if !r.options.Verbose {
-				fmt.Fprintln(r.stdout, "Test output:")
-				io.Copy(r.stdout, &outputBuf)
+		}()
+	}
+	for i := range testFiles {
+		if r.isInterrupted() {
+			break
+		}
+		select {
+		case indices <- i:
+		case <-r.interrupted:
+		}
+	}
+	close(indices)
+	wg.Wait()
+	r.concurrent = false
+	r.runRemainingTeardownHooks(hooks, testFolder)
+	return runs, hooks.results
+}
+
+// testRun is the outcome of running a single test script.
+type testRun struct {
+	result   TestResult
+	exitCode int
+	// started is set once the test is handed to a worker; it stays unset for
+	// the tests that were not run because the run was interrupted.
+	started bool
+}
+
+// runTestAt runs the i-th test in testFiles, retrying it if it fails, and
+// reports its progress and result.
+func (r *Runner) runTestAt(i int, testFiles []string, testFolder string) testRun {
+	testFile := testFiles[i]
+	r.testStart(i, testFile)
+	if r.options.ResultsDir != "" {
+		if err := r.removeTestResults(testFile); err != nil {
+			fmt.Fprintf(r.stderr, "Error removing the earlier results of the test: %v\n", err)
+		}
+	}
+	var failedAttempts []FailedResult
+	for {
+		result, exitCode := r.runAttempt(testFile, testFolder, r.interrupted)
+
+		failed := exitCode != 0 && exitCode != skipTestExitCode && exitCode != interruptedExitCode
+		if failed && len(failedAttempts) < r.options.Retries && !r.isInterrupted() {
+			if result.ResultsDir != "" {
+				attemptDir, err := r.keepFailedAttempt(testFile, len(failedAttempts)+1)
+				if err != nil {
+					fmt.Fprintf(r.stderr, "Error keeping the logs of the failed attempt: %v\n", err)
+				}
+				result.ResultsDir = attemptDir
 			}
+			failedAttempt := newFailedResult(result, exitCode)
+			failedAttempts = append(failedAttempts, failedAttempt)
+			r.testRetry(i, failedAttempt)
+			continue
 		}
+
+		result.FailedAttempts = failedAttempts
+		r.testEnd(i, result, exitCode)
+		return testRun{result: result, exitCode: exitCode}
 	}
-	return passedResults, skippedResults, failedResults
 }
 
-func (r *Runner) runSingleTest(testFile string, testFolder string, cmdStdout, cmdStderr io.Writer) (exitCode int) {
+// runAttempt runs a test once, capturing its output.  The test is stopped
+// when the interrupted channel is closed.
+func (r *Runner) runAttempt(testFile string, testFolder string, interrupted <-chan struct{}) (TestResult, int) {
+	// The output is always captured, so it can be included in the results,
+	// and passed to the reporters as it is printed.
+	var cmdStdout, cmdStderr io.Writer
+	var outputBuf, tapBuf bytes.Buffer
+	if r.textOutput() && r.options.Verbose && !r.concurrent {
+		// The text output prints the stderr of the tests to stderr, so
+		// stdout and stderr are copied by separate goroutines here.
+		stdoutWriter, stderrWriter := r.outputWriters(testFile)
+		lockedOutput := &lockedWriter{writer: &outputBuf}
+		cmdStdout = io.MultiWriter(lockedOutput, stdoutWriter)
+		cmdStderr = io.MultiWriter(lockedOutput, stderrWriter)
+	} else {
+		outputWriter := io.MultiWriter(&outputBuf, r.outputWriter(testFile))
+		cmdStdout = outputWriter
+		cmdStderr = outputWriter
+	}
+	parsesTAP := r.parsesTAP(testFile)
+	var logs *testLogs
+	if r.options.ResultsDir != "" {
+		var err error
+		if logs, err = r.createTestLogs(testFile); err != nil {
+			fmt.Fprintf(r.stderr, "Error creating the logs of the test: %v\n", err)
+		}
+	}
+	if (parsesTAP || logs != nil) && cmdStdout == cmdStderr {
+		// Stdout and stderr are copied by separate goroutines once stdout is
+		// also copied to be parsed, or they are copied to their own logs.
+		outputWriter := &lockedWriter{writer: cmdStdout}
+		cmdStdout = outputWriter
+		cmdStderr = outputWriter
+	}
+	if parsesTAP {
+		cmdStdout = io.MultiWriter(cmdStdout, &tapBuf)
+	}
+	if logs != nil {
+		cmdStdout, cmdStderr = logs.writers(cmdStdout, cmdStderr)
+	}
+
+	resultDir, err := createResultDir()
+	if err != nil {
+		fmt.Fprintf(r.stderr, "Error creating result directory: %v\n", err)
+	}
+	artifactsDir := ""
+	if logs != nil {
+		artifactsDir = logs.artifactsDir
+	}
+	startTime := time.Now()
+	exitCode, usage := r.runSingleTest(testFile, testFolder, resultDir, artifactsDir, interrupted, cmdStdout, cmdStderr)
+	endTime := time.Now()
+	result := TestResult{
+		TestFile:  testFile,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Duration:  endTime.Sub(startTime),
+		Usage:     usage,
+		Output:    outputBuf.String(),
+		Metadata:  r.testMetadata(testFile),
+	}
+	if resultDir != "" {
+		report, err := readResultDir(resultDir)
+		if err != nil {
+			fmt.Fprintf(r.stderr, "Error reading results: %v\n", err)
+		}
+		report.apply(&result, exitCode)
+		r.removeResultDir(resultDir)
+	}
+	if logs != nil {
+		logs.close()
+		result.ResultsDir = logs.dir
+		result.Artifacts = listArtifacts(logs.dir)
+	}
+	if parsesTAP {
+		result.SubTests = parseTAP(tapBuf.String())
+		if exitCode == 0 && result.failedSubTests() > 0 {
+			exitCode = failedSubTestsExitCode
+		}
+	}
+	return result, exitCode
+}
+
+// printFailure prints a failed attempt at running a test, along with its
+// output unless it was already shown.
+func (r *Runner) printFailure(out io.Writer, result FailedResult) {
+	fmt.Fprintln(out, result)
+	if !r.options.Verbose {
+		fmt.Fprintln(out, "Test output:")
+		fmt.Fprint(out, result.Output)
+	}
+}
+
+// lockedWriter serializes writes to a writer shared by several goroutines.
+type lockedWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(p)
+}
+
+// runSingleTest runs a test script, which may report more than its exit code
+// in the files of resultDir, and leave files to keep in artifactsDir, unless
+// they are empty.
+func (r *Runner) runSingleTest(testFile string, testFolder string, resultDir string, artifactsDir string, interrupted <-chan struct{}, cmdStdout, cmdStderr io.Writer) (exitCode int, usage ResourceUsage) {
 	testPath := filepath.Join(testFolder, testFile)
 
 	command := exec.Command(testPath)
 	command.Stdout = cmdStdout
 	command.Stderr = cmdStderr
+	setProcessGroup(command)
 
 	// Propagate timeout information from brain to script, via the environment of the script.
+	testTimeout := r.testTimeout(testFile)
 	env := os.Environ()
-	env = append(env, fmt.Sprintf("TESTBRAIN_TIMEOUT=%v", r.options.Timeout.Seconds()))
+	env = append(env, fmt.Sprintf("TESTBRAIN_TIMEOUT=%v", testTimeout.Seconds()))
+	if resultDir != "" {
+		env = append(env, resultEnv(resultDir)...)
+	}
+	if artifactsDir != "" {
+		env = append(env, fmt.Sprintf("%s=%s", artifactsDirEnv, artifactsDir))
+	}
 	command.Env = env
 
 	err := command.Start()
 	if err != nil {
 		fmt.Fprintf(r.stderr, "Test failed: %v", err)
-		return unknownExitCode
+		return unknownExitCode, usage
 	}
+	r.addRunning(command)
+	defer r.removeRunning(command)
 
-	done := make(chan error)
+	done := make(chan error, 1)
 	go func() {
 		done <- command.Wait()
 		close(done)
 	}()
 
-	timeout := time.After(r.options.Timeout)
+	timeout := time.After(testTimeout)
 
 	select {
 	case <-timeout:
-		command.Process.Kill()
-		fmt.Fprintf(r.stderr, "Killed by testbrain: Timed out after %v\n", r.options.Timeout)
-		return unknownExitCode
+		signal, exited := r.terminateTest(command, done)
+		fmt.Fprintf(r.stderr, "Killed by testbrain: Timed out after %v, terminated by %s\n", testTimeout, signal)
+		if exited {
+			usage = newResourceUsage(command.ProcessState)
+		}
+		return unknownExitCode, usage
+	case <-interrupted:
+		signal, exited := r.terminateTest(command, done)
+		fmt.Fprintf(r.stderr, "Killed by testbrain: Run interrupted, terminated by %s\n", signal)
+		if exited {
+			usage = newResourceUsage(command.ProcessState)
+		}
+		return interruptedExitCode, usage
 	case err = <-done:
+		usage = newResourceUsage(command.ProcessState)
 		exitCode, err = r.getErrorCode(err, command)
 		if err != nil {
 			fmt.Fprintf(r.stderr, "Test failed: %v", err)
@@ -298,6 +667,34 @@ func (r *Runner) runSingleTest(testFile string, testFolder string, cmdStdout, cm
 	return
 }
 
+// terminateTest stops a running test and all the processes it spawned.  The
+// process group of the test is sent SIGTERM first; if it has not exited once
+// the grace period is over, it is sent SIGKILL.  It returns the name of the
+// signal that ended the test, and whether the test was seen to exit, as the
+// state of its process can only be read then.
+func (r *Runner) terminateTest(command *exec.Cmd, done <-chan error) (string, bool) {
+	if r.options.KillGracePeriod > 0 && signalProcessGroup(command, syscall.SIGTERM) == nil {
+		select {
+		case <-done:
+			// The processes the test spawned may ignore SIGTERM and outlive
+			// it, so they are killed, if any are left.
+			signalProcessGroup(command, syscall.SIGKILL)
+			return "SIGTERM", true
+		case <-time.After(r.options.KillGracePeriod):
+		}
+	}
+	if err := signalProcessGroup(command, syscall.SIGKILL); err != nil {
+		fmt.Fprintf(r.stderr, "Error killing test: %v\n", err)
+	}
+	select {
+	case <-done:
+		return "SIGKILL", true
+	case <-time.After(killWaitTimeout):
+		fmt.Fprintf(r.stderr, "Test still running %v after being killed\n", killWaitTimeout)
+		return "SIGKILL", false
+	}
+}
+
 func (r *Runner) getErrorCode(err error, command *exec.Cmd) (int, error) {
 	if command.ProcessState.Success() {
 		// Not exactly necessary, since we can check Success(),
@@ -322,84 +719,317 @@ func (r *Runner) getErrorCode(err error, command *exec.Cmd) (int, error) {
 	return unknownExitCode, nil
 }
 
-func (r *Runner) outputResults(passedResults []PassedResult, skippedResults []SkippedResult, failedResults []FailedResult) {
-	summaryString := fmt.Sprintf(
-		"Tests complete: %d Passed, %d Skipped, %d Failed",
-		len(passedResults), len(skippedResults), len(failedResults))
-	if len(failedResults) > 0 {
+func (r *Runner) outputResults(results Results) {
+	var summaryString string
+	if results.Interrupted {
+		summaryString = fmt.Sprintf(
+			"Tests interrupted: %d Passed, %d Flaky, %d Skipped, %d Failed, %d Not run",
+			len(results.Passed), len(results.Flaky), len(results.Skipped), len(results.Failed), len(results.NotRun))
+	} else if r.options.Retries > 0 {
+		summaryString = fmt.Sprintf(
+			"Tests complete: %d Passed, %d Flaky, %d Skipped, %d Failed",
+			len(results.Passed), len(results.Flaky), len(results.Skipped), len(results.Failed))
+	} else {
+		summaryString = fmt.Sprintf(
+			"Tests complete: %d Passed, %d Skipped, %d Failed",
+			len(results.Passed), len(results.Skipped), len(results.Failed))
+	}
+	if len(results.Failed) > 0 || results.failedHooks() > 0 || results.Interrupted {
 		fmt.Fprintf(r.stdout, "%s\n\n", redBold(summaryString))
 	} else {
 		fmt.Fprintf(r.stdout, "%s\n\n", greenBold(summaryString))
 	}
 
-	if len(skippedResults) > 0 {
+	if len(results.Hooks) > 0 {
+		fmt.Fprintln(r.stdout, "  Hooks:")
+		for _, result := range results.Hooks {
+			fmt.Fprintf(r.stdout, "    %s %s %s (%v)\n", result.Hook, result.TestFile, result.status(), formatDuration(result.Duration))
+		}
+		fmt.Fprintf(r.stdout, "\n")
+	}
+
+	if len(results.Flaky) > 0 {
+		fmt.Fprintln(r.stdout, "  Flaky tests:")
+		for _, result := range results.Flaky {
+			fmt.Fprintf(r.stdout, "    %s passed after %d attempts (%v)\n", result.TestFile, len(result.FailedAttempts)+1, formatDuration(result.Duration))
+		}
+		fmt.Fprintf(r.stdout, "\n")
+	}
+
+	if len(results.Skipped) > 0 {
 		fmt.Fprintln(r.stdout, "  Skipped tests:")
-		for _, result := range skippedResults {
-			fmt.Fprintf(r.stdout, "    %s\n", result.TestFile)
+		for _, result := range results.Skipped {
+			if result.SkipReason != "" {
+				fmt.Fprintf(r.stdout, "    %s (%v): %s\n", result.TestFile, formatDuration(result.Duration), result.SkipReason)
+			} else {
+				fmt.Fprintf(r.stdout, "    %s (%v)\n", result.TestFile, formatDuration(result.Duration))
+			}
 		}
 		fmt.Fprintf(r.stdout, "\n")
 	}
 
-	if len(failedResults) > 0 {
+	if len(results.Failed) > 0 {
 		fmt.Fprintln(r.stdout, "  Failed tests:")
-		for _, result := range failedResults {
-			fmt.Fprintf(r.stdout, "    %s with exit code %d\n", result.TestFile, result.ExitCode)
+		for _, result := range results.Failed {
+			fmt.Fprintf(r.stdout, "    %s with %s (%v)\n", result.TestFile, result.reason(), formatDuration(result.Duration))
+			for _, subTest := range result.SubTests {
+				if subTest.Status == subTestFailed {
+					fmt.Fprintf(r.stdout, "      %d - %s\n", subTest.Number, subTest.Description)
+				}
+			}
 		}
 		fmt.Fprintf(r.stdout, "\n")
 	}
-}
 
-func (r *Runner) outputResultsJSON(passedResults []PassedResult, skippedResults []SkippedResult, failedResults []FailedResult) {
-	displayedSeed := r.options.RandomSeed
-	if r.options.InOrder {
-		displayedSeed = unknownExitCode
-	}
-
-	// This is the only place where we need this struct, so anonymous struct seems appropriate.
-	jsonOutputStruct := struct {
-		Passed      int             `json:"passed"`
-		Skipped     int             `json:"skipped"`
-		Failed      int             `json:"failed"`
-		Seed        int64           `json:"seed"`
-		InOrder     bool            `json:"inOrder"`
-		PassedList  []PassedResult  `json:"passedList"`
-		SkippedList []SkippedResult `json:"skippedList"`
-		FailedList  []FailedResult  `json:"failedList"`
-	}{
-		Passed:      len(passedResults),
-		Skipped:     len(skippedResults),
-		Failed:      len(failedResults),
-		Seed:        displayedSeed,
-		InOrder:     r.options.InOrder,
-		PassedList:  passedResults,
-		SkippedList: skippedResults,
-		FailedList:  failedResults,
+	// The tests that reported more than their exit code, in the order of the
+	// sections above.
+	var reported []TestResult
+	addReported := func(result TestResult) {
+		if result.hasReport() {
+			reported = append(reported, result)
+		}
+	}
+	for _, result := range results.Passed {
+		addReported(TestResult(result))
+	}
+	for _, result := range results.Flaky {
+		addReported(TestResult(result))
+	}
+	for _, result := range results.Skipped {
+		addReported(TestResult(result))
+	}
+	for _, result := range results.Failed {
+		addReported(result.TestResult)
+	}
+	if len(reported) > 0 {
+		fmt.Fprintln(r.stdout, "  Test reports:")
+		for _, result := range reported {
+			fmt.Fprintf(r.stdout, "    %s\n", result.TestFile)
+			for _, line := range result.reportLines() {
+				fmt.Fprintf(r.stdout, "      %s\n", line)
+			}
+		}
+		fmt.Fprintf(r.stdout, "\n")
+	}
+
+	if len(results.NotRun) > 0 {
+		fmt.Fprintln(r.stdout, "  Tests not run:")
+		for _, result := range results.NotRun {
+			fmt.Fprintf(r.stdout, "    %s\n", result.TestFile)
+		}
+		fmt.Fprintf(r.stdout, "\n")
 	}
+}
 
+func (r *Runner) outputResultsJSON(results Results) {
 	jsonEncoder := json.NewEncoder(r.stdout)
-	err := jsonEncoder.Encode(jsonOutputStruct)
+	err := jsonEncoder.Encode(r.jsonResults(results))
 	if err != nil {
 		fmt.Fprintln(r.stderr, redBold("Error trying to marshal JSON output"))
 	}
 }
 
+// displayedSeed is the seed shown in the JSON output, which is -1 when the
+// tests are not shuffled.
+func (r *Runner) displayedSeed() int64 {
+	if r.options.InOrder {
+		return unknownExitCode
+	}
+	return r.options.RandomSeed
+}
+
+// jsonResults is the JSON representation of the results of a run.
+type jsonResults struct {
+	Passed      int             `json:"passed"`
+	Flaky       int             `json:"flaky"`
+	Skipped     int             `json:"skipped"`
+	Failed      int             `json:"failed"`
+	NotRun      int             `json:"notRun"`
+	Interrupted bool            `json:"interrupted"`
+	Seed        int64           `json:"seed"`
+	InOrder     bool            `json:"inOrder"`
+	Shard       *ShardInfo      `json:"shard,omitempty"`
+	PassedList  []PassedResult  `json:"passedList"`
+	FlakyList   []FlakyResult   `json:"flakyList"`
+	SkippedList []SkippedResult `json:"skippedList"`
+	FailedList  []FailedResult  `json:"failedList"`
+	NotRunList  []NotRunResult  `json:"notRunList"`
+	Hooks       []HookResult    `json:"hooks"`
+}
+
+func (r *Runner) jsonResults(results Results) jsonResults {
+	return jsonResults{
+		Passed:      len(results.Passed),
+		Flaky:       len(results.Flaky),
+		Skipped:     len(results.Skipped),
+		Failed:      len(results.Failed),
+		NotRun:      len(results.NotRun),
+		Interrupted: results.Interrupted,
+		Seed:        r.displayedSeed(),
+		InOrder:     r.options.InOrder,
+		Shard:       r.shardInfo(),
+		PassedList:  results.Passed,
+		FlakyList:   results.Flaky,
+		SkippedList: results.Skipped,
+		FailedList:  results.Failed,
+		NotRunList:  results.NotRun,
+		Hooks:       results.Hooks,
+	}
+}
+
+// Results contains the results of all the tests of a run, by outcome.
+type Results struct {
+	Passed  []PassedResult
+	Flaky   []FlakyResult
+	Skipped []SkippedResult
+	Failed  []FailedResult
+	// NotRun are the tests that were not run, or did not complete, because
+	// the run was interrupted.
+	NotRun []NotRunResult
+	// Hooks are the setup and teardown hooks that were run, in order.
+	Hooks       []HookResult
+	Interrupted bool
+}
+
+// newResults returns empty results with the given hook results.
+func newResults(hookResults []HookResult, interrupted bool) Results {
+	results := Results{
+		Passed:      make([]PassedResult, 0),
+		Flaky:       make([]FlakyResult, 0),
+		Skipped:     make([]SkippedResult, 0),
+		Failed:      make([]FailedResult, 0),
+		NotRun:      make([]NotRunResult, 0),
+		Hooks:       hookResults,
+		Interrupted: interrupted,
+	}
+	if results.Hooks == nil {
+		results.Hooks = make([]HookResult, 0)
+	}
+	return results
+}
+
+// add adds the run of a test to the results, according to its outcome.
+func (results *Results) add(testFile string, run testRun) {
+	if !run.started {
+		results.NotRun = append(results.NotRun, NotRunResult{TestFile: testFile})
+	} else if run.exitCode == interruptedExitCode {
+		results.NotRun = append(results.NotRun, NotRunResult(run.result))
+	} else if run.exitCode == skipTestExitCode {
+		results.Skipped = append(results.Skipped, SkippedResult(run.result))
+	} else if run.exitCode == 0 && len(run.result.FailedAttempts) > 0 {
+		results.Flaky = append(results.Flaky, FlakyResult(run.result))
+	} else if run.exitCode == 0 {
+		results.Passed = append(results.Passed, PassedResult(run.result))
+	} else {
+		results.Failed = append(results.Failed, newFailedResult(run.result, run.exitCode))
+	}
+}
+
+// failedHooks returns the number of hooks that failed.
+func (results Results) failedHooks() int {
+	failed := 0
+	for _, result := range results.Hooks {
+		if result.failed() {
+			failed++
+		}
+	}
+	return failed
+}
+
 // TestResult contains the result of a single test script.
+// Durations are given in nanoseconds in the JSON output.
 type TestResult struct {
-	TestFile string `json:"filename"`
+	TestFile  string        `json:"filename"`
+	StartTime time.Time     `json:"startTime"`
+	EndTime   time.Time     `json:"endTime"`
+	Duration  time.Duration `json:"duration"`
+	Usage     ResourceUsage `json:"usage"`
+	Output    string        `json:"-"`
+	Metadata  *TestMetadata `json:"metadata,omitempty"`
+	// SkipReason tells why a test was skipped, as given by the runner or the
+	// test itself.
+	SkipReason string `json:"skipReason,omitempty"`
+	// UnmetRequirements lists the requirements of a test that were not met,
+	// so it was not run.
+	UnmetRequirements []string `json:"unmetRequirements,omitempty"`
+	// SubTests are the results of the checks of the test, when the TAP it
+	// printed is parsed.
+	SubTests []SubTestResult `json:"subTests,omitempty"`
+	// Values, Steps and Warnings are what the test reported in its result
+	// file.
+	Values   map[string]string `json:"values,omitempty"`
+	Steps    []StepResult      `json:"steps,omitempty"`
+	Warnings []string          `json:"warnings,omitempty"`
+	// ResultsDir is the directory of the test in the results directory,
+	// with its logs, and Artifacts are the files the test left in its
+	// artifacts directory, relative to ResultsDir.
+	ResultsDir string   `json:"resultsDir,omitempty"`
+	Artifacts  []string `json:"artifacts,omitempty"`
+	// FailedAttempts are the earlier attempts at running the test, when it
+	// was retried after failing.
+	FailedAttempts []FailedResult `json:"failedAttempts,omitempty"`
+}
+
+// ResourceUsage contains the resources used by a test script, including the
+// processes it waited for.
+type ResourceUsage struct {
+	UserTime   time.Duration `json:"userTime"`
+	SystemTime time.Duration `json:"systemTime"`
+	// MaxRSS is the maximum resident set size, in kilobytes.
+	MaxRSS int64 `json:"maxRSS"`
+}
+
+func newResourceUsage(state *os.ProcessState) ResourceUsage {
+	if state == nil {
+		// The test never finished.
+		return ResourceUsage{}
+	}
+	return ResourceUsage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+		MaxRSS:     maxRSS(state),
+	}
+}
+
+// formatDuration rounds the duration for display.
+func formatDuration(duration time.Duration) time.Duration {
+	return duration.Round(time.Millisecond)
 }
 
 // PassedResult is a type for a test result that passed.
 type PassedResult TestResult
 
 func (result PassedResult) String() string {
-	return fmt.Sprintf("%s: %s\n", greenBold("PASSED"), result.TestFile)
+	return fmt.Sprintf("%s: %s (%v)\n", greenBold("PASSED"), result.TestFile, formatDuration(result.Duration)) +
+		subTestsString(result.SubTests)
+}
+
+// FlakyResult is a type for a test result that failed, but passed when retried.
+type FlakyResult TestResult
+
+func (result FlakyResult) String() string {
+	return fmt.Sprintf("%s: %s (%v)\n", yellowBold("FLAKY"), result.TestFile, formatDuration(result.Duration)) +
+		subTestsString(result.SubTests)
 }
 
 // SkippedResult is a type for a test result that skipped.
 type SkippedResult TestResult
 
 func (result SkippedResult) String() string {
-	return fmt.Sprintf("%s: %s\n", yellowBold("SKIPPED"), result.TestFile)
+	if result.SkipReason != "" {
+		return fmt.Sprintf("%s: %s (%v): %s\n", yellowBold("SKIPPED"), result.TestFile, formatDuration(result.Duration), result.SkipReason) +
+			subTestsString(result.SubTests)
+	}
+	return fmt.Sprintf("%s: %s (%v)\n", yellowBold("SKIPPED"), result.TestFile, formatDuration(result.Duration)) +
+		subTestsString(result.SubTests)
+}
+
+// NotRunResult is a type for a test that was not run, or was terminated,
+// because the run was interrupted.
+type NotRunResult TestResult
+
+func (result NotRunResult) String() string {
+	return fmt.Sprintf("%s: %s\n", yellowBold("NOT RUN"), result.TestFile)
 }
 
 // FailedResult is a type for a test result that failed.
@@ -408,6 +1038,40 @@ type FailedResult struct {
 	ExitCode int `json:"exitcode"`
 }
 
+// MarshalJSON adds the output of a failed test to its result, as the output of
+// the failed attempts of a retried test is not found anywhere else.
+func (result FailedResult) MarshalJSON() ([]byte, error) {
+	type failedResult FailedResult
+	return json.Marshal(struct {
+		failedResult
+		Output string `json:"output,omitempty"`
+	}{failedResult(result), result.Output})
+}
+
 func (result FailedResult) String() string {
-	return fmt.Sprintf("%s: %s\n", redBold("FAILED"), result.TestFile)
+	return fmt.Sprintf("%s: %s (%v)\n", redBold("FAILED"), result.TestFile, formatDuration(result.Duration)) +
+		subTestsString(result.SubTests)
+}
+
+// newFailedResult returns the result of a failed test.  Tests that only
+// failed because of their sub-tests keep their exit code of 0.
+func newFailedResult(result TestResult, exitCode int) FailedResult {
+	if exitCode == failedSubTestsExitCode {
+		exitCode = 0
+	}
+	return FailedResult{TestResult: result, ExitCode: exitCode}
+}
+
+// reason describes why the test failed.
+func (result FailedResult) reason() string {
+	if len(result.UnmetRequirements) > 0 {
+		return requirementsReason(result.UnmetRequirements)
+	}
+	failedSubTests := result.failedSubTests()
+	if failedSubTests == 0 {
+		return fmt.Sprintf("exit code %d", result.ExitCode)
+	} else if result.ExitCode == 0 {
+		return fmt.Sprintf("%d failed sub-tests", failedSubTests)
+	}
+	return fmt.Sprintf("exit code %d and %d failed sub-tests", result.ExitCode, failedSubTests)
 }
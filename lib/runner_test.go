@@ -2,11 +2,15 @@ package lib
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -19,6 +23,7 @@ var (
 	defaultInclude = "_test\\.sh$"
 	defaultExclude = "^$"
 	defaultSeed    = time.Now().UnixNano()
+	startTime      = time.Date(2019, time.March, 12, 10, 0, 0, 0, time.UTC)
 
 	redBoldString = color.New(color.FgRed, color.Bold).SprintfFunc()
 )
@@ -43,45 +48,76 @@ func setupDefaultRunner(stdout io.Writer, stderr io.Writer) *Runner {
 	return runner
 }
 
+func setupTestResult(testFile string, duration time.Duration) TestResult {
+	return TestResult{
+		TestFile:  testFile,
+		StartTime: startTime,
+		EndTime:   startTime.Add(duration),
+		Duration:  duration,
+		Usage: ResourceUsage{
+			UserTime:   10 * time.Millisecond,
+			SystemTime: 5 * time.Millisecond,
+			MaxRSS:     2048,
+		},
+	}
+}
+
 func setupPassedTestResults() []PassedResult {
 	return []PassedResult{
-		PassedResult{
-			TestFile: "testfile-success-1",
-		},
-		PassedResult{
-			TestFile: "testfile-success-2",
-		},
+		PassedResult(setupTestResult("testfile-success-1", 1*time.Second)),
+		PassedResult(setupTestResult("testfile-success-2", 2*time.Second)),
 	}
 }
 
 func setupSkippedTestResults() []SkippedResult {
 	return []SkippedResult{
-		SkippedResult{
-			TestFile: "testfile-skip-1",
-		},
-		SkippedResult{
-			TestFile: "testfile-skip-2",
-		},
+		SkippedResult(setupTestResult("testfile-skip-1", 500*time.Millisecond)),
+		SkippedResult(setupTestResult("testfile-skip-2", 1500*time.Millisecond)),
 	}
 }
 
 func setupFailedTestResults() []FailedResult {
 	return []FailedResult{
 		FailedResult{
-			TestResult: TestResult{
-				TestFile: "testfile-failure-1",
-			},
-			ExitCode: 1,
+			TestResult: setupTestResult("testfile-failure-1", 3*time.Second),
+			ExitCode:   1,
 		},
 		FailedResult{
-			TestResult: TestResult{
-				TestFile: "testfile-failure-2",
-			},
-			ExitCode: 2,
+			TestResult: setupTestResult("testfile-failure-2", 4*time.Second),
+			ExitCode:   2,
 		},
 	}
 }
 
+func setupResults() Results {
+	return Results{
+		Passed:  setupPassedTestResults(),
+		Flaky:   []FlakyResult{},
+		Skipped: setupSkippedTestResults(),
+		Failed:  setupFailedTestResults(),
+		NotRun:  []NotRunResult{},
+		Hooks:   []HookResult{},
+	}
+}
+
+// expectedResultJSON returns the JSON fields of a result from setupTestResult.
+func expectedResultJSON(testFile string, endTime string, duration string) string {
+	return `"filename":"` + testFile + `",` +
+		`"startTime":"2019-03-12T10:00:00Z",` +
+		`"endTime":"` + endTime + `",` +
+		`"duration":` + duration + `,` +
+		`"usage":{"userTime":10000000,"systemTime":5000000,"maxRSS":2048}`
+}
+
+// durationRe matches the durations printed after test results.
+var durationRe = regexp.MustCompile(`\(\d+(\.\d+)?(ns|µs|ms|s)\)`)
+
+// stripDurations replaces the durations in the output, as they vary between
+// runs.
+func stripDurations(output string) string {
+	return durationRe.ReplaceAllString(output, "(DURATION)")
+}
+
 func TestGetTestScripts(t *testing.T) {
 	t.Parallel()
 
@@ -379,7 +415,7 @@ func TestRunSingleTestSuccess(t *testing.T) {
 	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
 	testFolder, _ := filepath.Abs("../testdata/success")
 	testFile := "hello_world_test.sh"
-	exitCode := r.runSingleTest(testFile, testFolder, ioutil.Discard, ioutil.Discard)
+	exitCode, _ := r.runSingleTest(testFile, testFolder, "", "", r.interrupted, ioutil.Discard, ioutil.Discard)
 	if exitCode != 0 {
 		t.Errorf("\nExpected ExitCode: %v\nHave: %v\n", 0, exitCode)
 	}
@@ -391,12 +427,104 @@ func TestRunSingleTestFailure(t *testing.T) {
 	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
 	testFolder, _ := filepath.Abs("../testdata/failure")
 	testFile := "failure_test.sh"
-	exitCode := r.runSingleTest(testFile, testFolder, ioutil.Discard, ioutil.Discard)
+	exitCode, _ := r.runSingleTest(testFile, testFolder, "", "", r.interrupted, ioutil.Discard, ioutil.Discard)
 	if exitCode != 42 {
 		t.Errorf("\nExpected ExitCode: %v\nHave: %v\n", 42, exitCode)
 	}
 }
 
+func TestRunAllTestsRecordsTiming(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	testFolder, _ := filepath.Abs("../testdata/success")
+	before := time.Now()
+	results := r.runAllTests([]string{"hello_world_test.sh"}, testFolder)
+	after := time.Now()
+	if len(results.Passed) != 1 {
+		t.Fatalf("Expected 1 passed result, have %v", results.Passed)
+	}
+	result := results.Passed[0]
+	if result.StartTime.Before(before) || result.EndTime.After(after) {
+		t.Errorf("Test ran from %v to %v, outside of %v to %v", result.StartTime, result.EndTime, before, after)
+	}
+	if duration := result.EndTime.Sub(result.StartTime); result.Duration != duration {
+		t.Errorf("\nExpected Duration: %v\nHave: %v\n", duration, result.Duration)
+	}
+	if result.Usage.MaxRSS <= 0 {
+		t.Errorf("Expected the maximum resident set size to be recorded, have %d", result.Usage.MaxRSS)
+	}
+	if expectedOutput := "Hello World!\n"; result.Output != expectedOutput {
+		t.Errorf("\nExpected Output: %q\nHave: %q\n", expectedOutput, result.Output)
+	}
+}
+
+func TestRunAllTestsRetries(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "testbrain-flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.Setenv("FLAKY_MARKER", filepath.Join(tempDir, "marker"))
+	defer os.Unsetenv("FLAKY_MARKER")
+
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.Retries = 2
+	r.setupReporters()
+	testFolder, _ := filepath.Abs("../testdata")
+	results := r.runAllTests([]string{"flaky/flaky_test.sh", "failure/failure_test.sh"}, testFolder)
+
+	if len(results.Passed) != 0 {
+		t.Errorf("Expected no passed results, have %v", results.Passed)
+	}
+	if len(results.Flaky) != 1 {
+		t.Fatalf("Expected 1 flaky result, have %v", results.Flaky)
+	}
+	flakyResult := results.Flaky[0]
+	if len(flakyResult.FailedAttempts) != 1 || flakyResult.FailedAttempts[0].ExitCode != 1 {
+		t.Errorf("Expected 1 failed attempt with exit code 1, have %v", flakyResult.FailedAttempts)
+	}
+	if expectedOutput := "Goodbye World!\n"; flakyResult.FailedAttempts[0].Output != expectedOutput {
+		t.Errorf("\nExpected Output of the failed attempt: %q\nHave: %q\n", expectedOutput, flakyResult.FailedAttempts[0].Output)
+	}
+	if expectedOutput := "Hello World!\n"; flakyResult.Output != expectedOutput {
+		t.Errorf("\nExpected Output: %q\nHave: %q\n", expectedOutput, flakyResult.Output)
+	}
+	if len(results.Failed) != 1 {
+		t.Fatalf("Expected 1 failed result, have %v", results.Failed)
+	}
+	if failedAttempts := results.Failed[0].FailedAttempts; len(failedAttempts) != 2 {
+		t.Errorf("Expected 2 failed attempts, have %v", failedAttempts)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Running test flaky/flaky_test.sh (1/2)\n" +
+		"FAILED: flaky/flaky_test.sh (DURATION)\n\n" +
+		"Test output:\n" +
+		"Goodbye World!\n" +
+		"Retrying test flaky/flaky_test.sh (attempt 2/3)\n" +
+		"FLAKY: flaky/flaky_test.sh (DURATION)\n\n" +
+		"Running test failure/failure_test.sh (2/2)\n" +
+		"FAILED: failure/failure_test.sh (DURATION)\n\n" +
+		"Test output:\n" +
+		"Goodbye World!\n" +
+		"Retrying test failure/failure_test.sh (attempt 2/3)\n" +
+		"FAILED: failure/failure_test.sh (DURATION)\n\n" +
+		"Test output:\n" +
+		"Goodbye World!\n" +
+		"Retrying test failure/failure_test.sh (attempt 3/3)\n" +
+		"FAILED: failure/failure_test.sh (DURATION)\n\n" +
+		"Test output:\n" +
+		"Goodbye World!\n"
+	if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
 func TestRunSingleTestTimeout(t *testing.T) {
 	t.Parallel()
 
@@ -405,6 +533,7 @@ func TestRunSingleTestTimeout(t *testing.T) {
 	tests := []struct {
 		title            string
 		verbose          bool
+		gracePeriod      time.Duration
 		expectedExitCode int
 		expectedStdout   string
 		expectedStderr   string
@@ -415,7 +544,7 @@ func TestRunSingleTestTimeout(t *testing.T) {
 			expectedExitCode: unknownExitCode,
 			expectedStdout: "Timeout = 1\n" +
 				"Long running process...\n",
-			expectedStderr: "Killed by testbrain: Timed out after 1s\n",
+			expectedStderr: "Killed by testbrain: Timed out after 1s, terminated by SIGKILL\n",
 		},
 		{
 			title:            "non-verbose",
@@ -423,7 +552,16 @@ func TestRunSingleTestTimeout(t *testing.T) {
 			expectedExitCode: unknownExitCode,
 			expectedStdout: "Timeout = 1\n" +
 				"Long running process...\n",
-			expectedStderr: "Killed by testbrain: Timed out after 1s\n",
+			expectedStderr: "Killed by testbrain: Timed out after 1s, terminated by SIGKILL\n",
+		},
+		{
+			title:            "grace period",
+			verbose:          false,
+			gracePeriod:      5 * time.Second,
+			expectedExitCode: unknownExitCode,
+			expectedStdout: "Timeout = 1\n" +
+				"Long running process...\n",
+			expectedStderr: "Killed by testbrain: Timed out after 1s, terminated by SIGTERM\n",
 		},
 	}
 
@@ -436,8 +574,9 @@ func TestRunSingleTestTimeout(t *testing.T) {
 			r := setupDefaultRunner(&stdout, &stderr)
 			r.options.Timeout = 1 * time.Second
 			r.options.Verbose = tt.verbose
+			r.options.KillGracePeriod = tt.gracePeriod
 
-			exitCode := r.runSingleTest(testFile, testFolder, &stdout, &stderr)
+			exitCode, _ := r.runSingleTest(testFile, testFolder, "", "", r.interrupted, &stdout, &stderr)
 			if exitCode != tt.expectedExitCode {
 				t.Errorf("\nExpected ExitCode: %v\nHave: %v\n", tt.expectedExitCode, exitCode)
 			}
@@ -460,23 +599,60 @@ func TestRunSingleTestTimeout(t *testing.T) {
 	}
 }
 
+func TestRunSingleTestTimeoutIgnoringSIGTERM(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(&stdout, &stderr)
+	r.options.Timeout = 1 * time.Second
+	r.options.KillGracePeriod = 1 * time.Second
+
+	testFolder, _ := filepath.Abs("../testdata")
+	start := time.Now()
+	exitCode, _ := r.runSingleTest("ignore_sigterm_test.sh", testFolder, "", "", r.interrupted, &stdout, &stderr)
+	if exitCode != unknownExitCode {
+		t.Errorf("\nExpected ExitCode: %v\nHave: %v\n", unknownExitCode, exitCode)
+	}
+	// The background sleep holds on to the output of the test; had it survived,
+	// we would have waited for killWaitTimeout.
+	if elapsed := time.Since(start); elapsed >= killWaitTimeout {
+		t.Errorf("Test took %v to be killed, child processes were not killed", elapsed)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Ignoring SIGTERM...\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStderr := "Killed by testbrain: Timed out after 1s, terminated by SIGKILL\n"
+	if stderrStr := string(stderrBytes); stderrStr != expectedStderr {
+		t.Errorf("\nExpected stderr:\n%q\n\nHave:\n%q\n", expectedStderr, stderrStr)
+	}
+}
+
 func TestOutputResults(t *testing.T) {
 	var stdout concurrentBuffer
 	var stderr concurrentBuffer
 	r := setupDefaultRunner(&stdout, &stderr)
 	r.options.RandomSeed = 42
-	passedResults := setupPassedTestResults()
-	skippedResults := setupSkippedTestResults()
-	failedResults := setupFailedTestResults()
+	results := setupResults()
 
-	r.outputResults(passedResults, skippedResults, failedResults)
+	r.outputResults(results)
 	expectedStdout := "Tests complete: 2 Passed, 2 Skipped, 2 Failed\n\n" +
 		"  Skipped tests:\n" +
-		"    testfile-skip-1\n" +
-		"    testfile-skip-2\n\n" +
+		"    testfile-skip-1 (500ms)\n" +
+		"    testfile-skip-2 (1.5s)\n\n" +
 		"  Failed tests:\n" +
-		"    testfile-failure-1 with exit code 1\n" +
-		"    testfile-failure-2 with exit code 2\n\n"
+		"    testfile-failure-1 with exit code 1 (3s)\n" +
+		"    testfile-failure-2 with exit code 2 (4s)\n\n"
 	expectedStderr := ""
 	stdoutBytes, err := ioutil.ReadAll(&stdout)
 	if err != nil {
@@ -494,25 +670,64 @@ func TestOutputResults(t *testing.T) {
 	}
 }
 
+func TestOutputResultsFlaky(t *testing.T) {
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.Retries = 2
+	flakyResult := FlakyResult(setupTestResult("testfile-flaky", 2*time.Second))
+	flakyResult.FailedAttempts = []FailedResult{
+		FailedResult{
+			TestResult: setupTestResult("testfile-flaky", 1*time.Second),
+			ExitCode:   1,
+		},
+	}
+	results := Results{
+		Passed: setupPassedTestResults(),
+		Flaky:  []FlakyResult{flakyResult},
+	}
+
+	r.outputResults(results)
+	expectedStdout := "Tests complete: 2 Passed, 1 Flaky, 0 Skipped, 0 Failed\n\n" +
+		"  Flaky tests:\n" +
+		"    testfile-flaky passed after 2 attempts (2s)\n\n"
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
 func TestOutputResultsJSON(t *testing.T) {
 	var stdout concurrentBuffer
 	var stderr concurrentBuffer
 	r := setupDefaultRunner(&stdout, &stderr)
 	r.options.RandomSeed = 42
-	passedResults := setupPassedTestResults()
-	skippedResults := setupSkippedTestResults()
-	failedResults := setupFailedTestResults()
+	results := setupResults()
 
-	r.outputResultsJSON(passedResults, skippedResults, failedResults)
+	r.outputResultsJSON(results)
 	expectedStdout := "{" +
 		`"passed":2,` +
+		`"flaky":0,` +
 		`"skipped":2,` +
 		`"failed":2,` +
+		`"notRun":0,` +
+		`"interrupted":false,` +
 		`"seed":42,` +
 		`"inOrder":false,` +
-		`"passedList":[{"filename":"testfile-success-1"},{"filename":"testfile-success-2"}],` +
-		`"skippedList":[{"filename":"testfile-skip-1"},{"filename":"testfile-skip-2"}],` +
-		`"failedList":[{"filename":"testfile-failure-1","exitcode":1},{"filename":"testfile-failure-2","exitcode":2}]` +
+		`"passedList":[` +
+		`{` + expectedResultJSON("testfile-success-1", "2019-03-12T10:00:01Z", "1000000000") + `},` +
+		`{` + expectedResultJSON("testfile-success-2", "2019-03-12T10:00:02Z", "2000000000") + `}],` +
+		`"flakyList":[],` +
+		`"skippedList":[` +
+		`{` + expectedResultJSON("testfile-skip-1", "2019-03-12T10:00:00.5Z", "500000000") + `},` +
+		`{` + expectedResultJSON("testfile-skip-2", "2019-03-12T10:00:01.5Z", "1500000000") + `}],` +
+		`"failedList":[` +
+		`{` + expectedResultJSON("testfile-failure-1", "2019-03-12T10:00:03Z", "3000000000") + `,"exitcode":1},` +
+		`{` + expectedResultJSON("testfile-failure-2", "2019-03-12T10:00:04Z", "4000000000") + `,"exitcode":2}],` +
+		`"notRunList":[],` +
+		`"hooks":[]` +
 		"}\n"
 	expectedStderr := ""
 	stdoutBytes, err := ioutil.ReadAll(&stdout)
@@ -531,6 +746,64 @@ func TestOutputResultsJSON(t *testing.T) {
 	}
 }
 
+func TestOutputResultsJSONFailedAttempts(t *testing.T) {
+	t.Parallel()
+
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	failedAttempt := FailedResult{
+		TestResult: TestResult{TestFile: "testfile-flaky", Output: "Goodbye World!\n"},
+		ExitCode:   3,
+	}
+	results := newResults(nil, false)
+	results.Flaky = []FlakyResult{{
+		TestFile:       "testfile-flaky",
+		Output:         "Hello World!\n",
+		FailedAttempts: []FailedResult{failedAttempt},
+	}}
+	results.Failed = []FailedResult{{
+		TestResult: TestResult{
+			TestFile:       "testfile-failure",
+			Output:         "Still failing\n",
+			FailedAttempts: []FailedResult{failedAttempt},
+		},
+		ExitCode: 1,
+	}}
+
+	r.outputResultsJSON(results)
+	type attempt struct {
+		Output   string `json:"output"`
+		ExitCode int    `json:"exitcode"`
+	}
+	var output struct {
+		FlakyList []struct {
+			Output         string    `json:"output"`
+			FailedAttempts []attempt `json:"failedAttempts"`
+		} `json:"flakyList"`
+		FailedList []struct {
+			attempt
+			FailedAttempts []attempt `json:"failedAttempts"`
+		} `json:"failedList"`
+	}
+	if err := json.NewDecoder(&stdout).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedAttempts := []attempt{{Output: "Goodbye World!\n", ExitCode: 3}}
+	if len(output.FlakyList) != 1 || !reflect.DeepEqual(output.FlakyList[0].FailedAttempts, expectedAttempts) {
+		t.Errorf("\nExpected the failed attempts of the flaky test:\n%v\nHave:\n%+v\n", expectedAttempts, output.FlakyList)
+	}
+	// Only the output of failed tests is kept.
+	if len(output.FlakyList) == 1 && output.FlakyList[0].Output != "" {
+		t.Errorf("Expected no output for the flaky test, have %q", output.FlakyList[0].Output)
+	}
+	expectedFailed := attempt{Output: "Still failing\n", ExitCode: 1}
+	if len(output.FailedList) != 1 || output.FailedList[0].attempt != expectedFailed ||
+		!reflect.DeepEqual(output.FailedList[0].FailedAttempts, expectedAttempts) {
+		t.Errorf("\nExpected the failed test %v with the failed attempts:\n%v\nHave:\n%+v\n", expectedFailed, expectedAttempts, output.FailedList)
+	}
+}
+
 func TestRunCommand(t *testing.T) {
 	testFolder, _ := filepath.Abs("../testdata/mixed")
 	seed := int64(1552072438299530183)
@@ -548,18 +821,18 @@ func TestRunCommand(t *testing.T) {
 				fmt.Sprintf("Using seed: %d\n", seed) +
 				"Running test fail_test.sh (1/3)\n" +
 				"Goodbye World!\n" +
-				"FAILED: fail_test.sh\n\n" +
+				"FAILED: fail_test.sh (DURATION)\n\n" +
 				"Running test success_test.sh (2/3)\n" +
 				"Hello World!\n" +
-				"PASSED: success_test.sh\n\n" +
+				"PASSED: success_test.sh (DURATION)\n\n" +
 				"Running test skip_test.sh (3/3)\n" +
 				"Something stdout\n" +
-				"SKIPPED: skip_test.sh\n\n" +
+				"SKIPPED: skip_test.sh (DURATION): Nothing to test here\n\n" +
 				"Tests complete: 1 Passed, 1 Skipped, 1 Failed\n\n" +
 				"  Skipped tests:\n" +
-				"    skip_test.sh\n\n" +
+				"    skip_test.sh (DURATION): Nothing to test here\n\n" +
 				"  Failed tests:\n" +
-				"    fail_test.sh with exit code 42\n\n",
+				"    fail_test.sh with exit code 42 (DURATION)\n\n",
 			expectedStderr: "Something stderr\n",
 		},
 		{
@@ -568,18 +841,18 @@ func TestRunCommand(t *testing.T) {
 			expectedStdout: "Found 3 test files\n" +
 				fmt.Sprintf("Using seed: %d\n", seed) +
 				"Running test fail_test.sh (1/3)\n" +
-				"FAILED: fail_test.sh\n\n" +
+				"FAILED: fail_test.sh (DURATION)\n\n" +
 				"Test output:\n" +
 				"Goodbye World!\n" +
 				"Running test success_test.sh (2/3)\n" +
-				"PASSED: success_test.sh\n\n" +
+				"PASSED: success_test.sh (DURATION)\n\n" +
 				"Running test skip_test.sh (3/3)\n" +
-				"SKIPPED: skip_test.sh\n\n" +
+				"SKIPPED: skip_test.sh (DURATION): Nothing to test here\n\n" +
 				"Tests complete: 1 Passed, 1 Skipped, 1 Failed\n\n" +
 				"  Skipped tests:\n" +
-				"    skip_test.sh\n\n" +
+				"    skip_test.sh (DURATION): Nothing to test here\n\n" +
 				"  Failed tests:\n" +
-				"    fail_test.sh with exit code 42\n\n",
+				"    fail_test.sh with exit code 42 (DURATION)\n\n",
 			expectedStderr: "",
 		},
 	}
@@ -600,7 +873,7 @@ func TestRunCommand(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			if stdoutStr := string(stdoutBytes); stdoutStr != tt.expectedStdout {
+			if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != tt.expectedStdout {
 				t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", tt.expectedStdout, stdoutStr)
 			}
 			stderrBytes, err := ioutil.ReadAll(&stderr)
@@ -614,6 +887,153 @@ func TestRunCommand(t *testing.T) {
 	}
 }
 
+func TestRunCommandParallel(t *testing.T) {
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	seed := int64(1552072438299530183)
+
+	for _, verbose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verbose=%v", verbose), func(t *testing.T) {
+			var stdout concurrentBuffer
+			var stderr concurrentBuffer
+			r := setupDefaultRunner(&stdout, &stderr)
+			r.options.RandomSeed = seed
+			r.options.Verbose = verbose
+			r.options.Parallelism = 3
+			r.options.TestTargets = []string{testFolder}
+			err := r.RunCommand()
+			if err == nil {
+				t.Errorf("Expected an error, got nothing")
+			}
+			stdoutBytes, err := ioutil.ReadAll(&stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stdoutStr := stripDurations(string(stdoutBytes))
+
+			// The order in which the tests finish is not deterministic, but the
+			// output of each test must be printed in one block.
+			expectedBlocks := []string{
+				"Running test fail_test.sh (1/3)\n" +
+					"Goodbye World!\n" +
+					"FAILED: fail_test.sh (DURATION)\n\n",
+				"Running test success_test.sh (2/3)\n" +
+					"Hello World!\n" +
+					"PASSED: success_test.sh (DURATION)\n\n",
+				"Running test skip_test.sh (3/3)\n" +
+					"Something stdout\n" +
+					"Something stderr\n" +
+					"SKIPPED: skip_test.sh (DURATION): Nothing to test here\n\n",
+			}
+			if !verbose {
+				expectedBlocks = []string{
+					"Running test fail_test.sh (1/3)\n" +
+						"FAILED: fail_test.sh (DURATION)\n\n" +
+						"Test output:\n" +
+						"Goodbye World!\n",
+					"Running test success_test.sh (2/3)\n" +
+						"PASSED: success_test.sh (DURATION)\n\n",
+					"Running test skip_test.sh (3/3)\n" +
+						"SKIPPED: skip_test.sh (DURATION): Nothing to test here\n\n",
+				}
+			}
+			for _, block := range expectedBlocks {
+				if !strings.Contains(stdoutStr, block) {
+					t.Errorf("\nExpected stdout to contain:\n%q\n\nHave:\n%q\n", block, stdoutStr)
+				}
+			}
+			expectedSummary := "Tests complete: 1 Passed, 1 Skipped, 1 Failed\n\n" +
+				"  Skipped tests:\n" +
+				"    skip_test.sh (DURATION): Nothing to test here\n\n" +
+				"  Failed tests:\n" +
+				"    fail_test.sh with exit code 42 (DURATION)\n\n"
+			if !strings.HasSuffix(stdoutStr, expectedSummary) {
+				t.Errorf("\nExpected stdout to end with:\n%q\n\nHave:\n%q\n", expectedSummary, stdoutStr)
+			}
+		})
+	}
+}
+
+func TestRunCommandInterrupted(t *testing.T) {
+	testFolder, _ := filepath.Abs("../testdata/interrupted")
+
+	var stdout concurrentBuffer
+	var stderr concurrentBuffer
+	r := setupDefaultRunner(&stdout, &stderr)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+
+	go func() {
+		// Wait for the long running test to start.
+		for {
+			r.runningLock.Lock()
+			running := len(r.running)
+			r.runningLock.Unlock()
+			if running > 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		r.Interrupt()
+	}()
+	if err := r.RunCommand(); err != ErrInterrupted {
+		t.Errorf("Expected error %v, have %v", ErrInterrupted, err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 2 test files\n" +
+		"Running test long_test.sh (1/2)\n" +
+		"INTERRUPTED: long_test.sh (DURATION)\n\n" +
+		"Tests interrupted: 0 Passed, 0 Flaky, 0 Skipped, 0 Failed, 2 Not run\n\n" +
+		"  Tests not run:\n" +
+		"    long_test.sh\n" +
+		"    success_test.sh\n\n"
+	if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+	stderrBytes, err := ioutil.ReadAll(&stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStderr := "Killed by testbrain: Run interrupted, terminated by SIGKILL\n"
+	if stderrStr := string(stderrBytes); stderrStr != expectedStderr {
+		t.Errorf("\nExpected stderr:\n%q\n\nHave:\n%q\n", expectedStderr, stderrStr)
+	}
+}
+
+func TestRunCommandDryRun(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/metadata")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.DryRun = true
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 2 test files\n" +
+		fmt.Sprintf("Test root: %s\n", testFolder) +
+		"Test files:\n" +
+		"\tplain_test.sh (owner=nobody)\n" +
+		"\tslow_test.sh (timeout=1s tags=cf,slow owner=team-x requires=CF_DOMAIN flavor=vanilla)\n"
+	if os.Getenv("CF_DOMAIN") == "" {
+		expectedStdout += "Unmet requirements:\n" +
+			"\tslow_test.sh: CF_DOMAIN is not set\n"
+	}
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
 func TestRunCommandFailure(t *testing.T) {
 	testFolder, _ := filepath.Abs("../testdata/failure")
 	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
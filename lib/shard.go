@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ShardInfo describes the shard of the tests that was run.
+type ShardInfo struct {
+	Index int `json:"index"`
+	Total int `json:"total"`
+	// DiscoveredTests is the number of tests across all the shards.
+	DiscoveredTests int `json:"discoveredTests"`
+	// BalanceFrom and EstimatedDuration are set when the shards were
+	// balanced using the durations of an earlier run.
+	BalanceFrom       string        `json:"balanceFrom,omitempty"`
+	EstimatedDuration time.Duration `json:"estimatedDuration,omitempty"`
+}
+
+// sharded tells whether the tests are split between several runs.
+func (r *Runner) sharded() bool {
+	return r.options.ShardTotal > 1
+}
+
+// shardInfo returns the shard of the tests that is run, or nil if the tests
+// are not split.
+func (r *Runner) shardInfo() *ShardInfo {
+	if !r.sharded() {
+		return nil
+	}
+	return &ShardInfo{
+		Index:             r.options.ShardIndex,
+		Total:             r.options.ShardTotal,
+		DiscoveredTests:   r.discoveredTests,
+		BalanceFrom:       r.options.BalanceFrom,
+		EstimatedDuration: r.shardEstimate,
+	}
+}
+
+// shard returns the tests of the shard to run out of the sorted list of all
+// the tests.  Every ShardTotal-th test is taken, so that the shards are
+// disjoint and of even sizes, and do not depend on the seed.  With
+// BalanceFrom, the tests are balanced between the shards by their earlier
+// durations instead.
+func (r *Runner) shard(testFiles []string) ([]string, error) {
+	if !r.sharded() {
+		return testFiles, nil
+	}
+	if r.options.BalanceFrom != "" {
+		results, err := readJSONResults(r.options.BalanceFrom)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading test durations from %s: %s", r.options.BalanceFrom, err)
+		}
+		shards := balanceShards(testFiles, results.durations(), r.options.ShardTotal)
+		shard := shards[r.options.ShardIndex]
+		r.shardEstimate = shard.duration
+		return shard.testFiles, nil
+	}
+	var shardFiles []string
+	for i := r.options.ShardIndex; i < len(testFiles); i += r.options.ShardTotal {
+		shardFiles = append(shardFiles, testFiles[i])
+	}
+	return shardFiles, nil
+}
+
+// balancedShard is one of the shards the tests are split into.
+type balancedShard struct {
+	testFiles []string
+	// duration is the sum of the known durations of the tests.
+	duration time.Duration
+	// unknown is the number of tests without a known duration.
+	unknown int
+}
+
+// balanceShards splits the sorted tests into shards that should take about
+// as long to run.  The tests with a known duration are handed out longest
+// first, each to the shard with the shortest total duration so far; the
+// tests without one are then spread so that the shards have even numbers of
+// them.  Ties go to the first shard, so that every node computes the same
+// shards.  The tests of each shard keep their sorted order.
+func balanceShards(testFiles []string, durations map[string]time.Duration, total int) []balancedShard {
+	var known, unknown []string
+	for _, testFile := range testFiles {
+		if _, ok := durations[testFile]; ok {
+			known = append(known, testFile)
+		} else {
+			unknown = append(unknown, testFile)
+		}
+	}
+	sort.SliceStable(known, func(i, j int) bool {
+		return durations[known[i]] > durations[known[j]]
+	})
+
+	shards := make([]balancedShard, total)
+	assignment := make(map[string]int, len(testFiles))
+	for _, testFile := range known {
+		shardIndex := 0
+		for i := range shards {
+			if shards[i].duration < shards[shardIndex].duration {
+				shardIndex = i
+			}
+		}
+		shards[shardIndex].duration += durations[testFile]
+		assignment[testFile] = shardIndex
+	}
+	for _, testFile := range unknown {
+		shardIndex := 0
+		for i := range shards {
+			if shards[i].unknown < shards[shardIndex].unknown ||
+				(shards[i].unknown == shards[shardIndex].unknown && shards[i].duration < shards[shardIndex].duration) {
+				shardIndex = i
+			}
+		}
+		shards[shardIndex].unknown++
+		assignment[testFile] = shardIndex
+	}
+	for _, testFile := range testFiles {
+		shardIndex := assignment[testFile]
+		shards[shardIndex].testFiles = append(shards[shardIndex].testFiles, testFile)
+	}
+	return shards
+}
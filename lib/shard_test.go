@@ -0,0 +1,239 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGetTestScriptsWithOrder_Shards(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/testfolder3-many-tests")
+	expected := [][]string{
+		{"000_script_test.sh", "003_script_test.sh"},
+		{"001_script_test.sh", "004_script_test.sh"},
+		{"002_script_test.sh"},
+	}
+	for shardIndex, expectedShard := range expected {
+		// The shards do not depend on the seed.
+		for _, seed := range []int64{1, 42} {
+			r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+			r.options.TestTargets = []string{testFolder}
+			r.options.RandomSeed = seed
+			r.options.ShardIndex = shardIndex
+			r.options.ShardTotal = len(expected)
+
+			_, testScripts, err := r.getTestScriptsWithOrder()
+			if err != nil {
+				t.Errorf("Error getting test scripts: %s", err)
+			}
+			sort.Strings(testScripts)
+			if !reflect.DeepEqual(expectedShard, testScripts) {
+				t.Errorf("\nExpected shard %d with seed %d:\n%v\nHave:\n%v\n", shardIndex, seed, expectedShard, testScripts)
+			}
+			if r.discoveredTests != 5 {
+				t.Errorf("Expected 5 discovered tests, have %d", r.discoveredTests)
+			}
+		}
+	}
+}
+
+func TestRunCommandDryRunShard(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/testfolder3-many-tests")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.DryRun = true
+	r.options.TestTargets = []string{testFolder}
+	r.options.ShardIndex = 1
+	r.options.ShardTotal = 2
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 2 test files\n" +
+		"Using shard: 1 of 2 (2 of 5 test files)\n" +
+		fmt.Sprintf("Test root: %s\n", testFolder) +
+		"Test files:\n" +
+		"\t001_script_test.sh\n" +
+		"\t003_script_test.sh\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestJSONResultsShard(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	if shard := r.jsonResults(setupResults()).Shard; shard != nil {
+		t.Errorf("Expected no shard when the tests are not split, have %+v", shard)
+	}
+	r.options.ShardIndex = 2
+	r.options.ShardTotal = 3
+	r.discoveredTests = 10
+	expected := &ShardInfo{Index: 2, Total: 3, DiscoveredTests: 10}
+	if shard := r.jsonResults(setupResults()).Shard; !reflect.DeepEqual(shard, expected) {
+		t.Errorf("Expected shard %+v, have %+v", expected, shard)
+	}
+}
+
+func TestBalanceShards(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		testFiles []string
+		durations map[string]time.Duration
+		expected  [][]string
+	}{
+		{
+			name:      "no durations",
+			testFiles: []string{"a", "b", "c", "d", "e"},
+			durations: map[string]time.Duration{},
+			expected:  [][]string{{"a", "c", "e"}, {"b", "d"}},
+		},
+		{
+			name:      "long test alone",
+			testFiles: []string{"a", "b", "c", "d"},
+			durations: map[string]time.Duration{
+				"a": 1 * time.Minute,
+				"b": 20 * time.Minute,
+				"c": 2 * time.Minute,
+				"d": 3 * time.Minute,
+			},
+			expected: [][]string{{"b"}, {"a", "c", "d"}},
+		},
+		{
+			name:      "longest first",
+			testFiles: []string{"a", "b", "c", "d", "e"},
+			durations: map[string]time.Duration{
+				"a": 3 * time.Minute,
+				"b": 3 * time.Minute,
+				"c": 2 * time.Minute,
+				"d": 2 * time.Minute,
+				"e": 2 * time.Minute,
+			},
+			expected: [][]string{{"a", "c", "e"}, {"b", "d"}},
+		},
+		{
+			name:      "tests without history",
+			testFiles: []string{"a", "b", "c", "d", "e"},
+			durations: map[string]time.Duration{
+				"a": 10 * time.Minute,
+				"b": 1 * time.Minute,
+			},
+			expected: [][]string{{"a", "d"}, {"b", "c", "e"}},
+		},
+	}
+	for _, tt := range tests {
+		shards := balanceShards(tt.testFiles, tt.durations, len(tt.expected))
+		var testFiles [][]string
+		for _, shard := range shards {
+			testFiles = append(testFiles, shard.testFiles)
+		}
+		if !reflect.DeepEqual(testFiles, tt.expected) {
+			t.Errorf("\nExpected shards for %s:\n%v\nHave:\n%v\n", tt.name, tt.expected, testFiles)
+		}
+	}
+}
+
+func TestReadJSONResults(t *testing.T) {
+	t.Parallel()
+
+	expected := map[string]time.Duration{
+		"000_script_test.sh": 20 * time.Minute,
+		"001_script_test.sh": 1 * time.Minute,
+	}
+	for _, reportFile := range []string{"many_tests.json", "many_tests_stream.json"} {
+		results, err := readJSONResults(filepath.Join("../testdata/reports", reportFile))
+		if err != nil {
+			t.Errorf("Error reading %s: %s", reportFile, err)
+			continue
+		}
+		durations := results.durations()
+		for testFile, duration := range expected {
+			if durations[testFile] != duration {
+				t.Errorf("Expected duration of %s in %s to be %v, have %v", testFile, reportFile, duration, durations[testFile])
+			}
+		}
+	}
+
+	if _, err := readJSONResults("../testdata/reports/missing.json"); err == nil {
+		t.Errorf("Expected an error reading a missing file")
+	}
+	if _, err := readJSONResults("../testdata/testfolder1/000_script_test.sh"); err == nil {
+		t.Errorf("Expected an error reading a file that is not JSON")
+	}
+}
+
+func TestGetTestScriptsWithOrder_BalancedShards(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/testfolder3-many-tests")
+	expected := [][]string{
+		{"000_script_test.sh"},
+		{"001_script_test.sh", "002_script_test.sh", "003_script_test.sh", "004_script_test.sh"},
+	}
+	expectedEstimates := []time.Duration{20 * time.Minute, 3*time.Minute + 1*time.Second}
+	for shardIndex, expectedShard := range expected {
+		r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+		r.options.TestTargets = []string{testFolder}
+		r.options.ShardIndex = shardIndex
+		r.options.ShardTotal = len(expected)
+		r.options.BalanceFrom = "../testdata/reports/many_tests.json"
+
+		_, testScripts, err := r.getTestScriptsWithOrder()
+		if err != nil {
+			t.Errorf("Error getting test scripts: %s", err)
+		}
+		sort.Strings(testScripts)
+		if !reflect.DeepEqual(expectedShard, testScripts) {
+			t.Errorf("\nExpected shard %d:\n%v\nHave:\n%v\n", shardIndex, expectedShard, testScripts)
+		}
+		if r.shardEstimate != expectedEstimates[shardIndex] {
+			t.Errorf("Expected shard %d to take %v, have %v", shardIndex, expectedEstimates[shardIndex], r.shardEstimate)
+		}
+	}
+}
+
+func TestRunCommandDryRunBalancedShard(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/testfolder3-many-tests")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.DryRun = true
+	r.options.TestTargets = []string{testFolder}
+	r.options.ShardIndex = 0
+	r.options.ShardTotal = 2
+	r.options.BalanceFrom = "../testdata/reports/many_tests.json"
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 1 test files\n" +
+		"Using shard: 0 of 2 (1 of 5 test files, estimated 20m0s from ../testdata/reports/many_tests.json)\n" +
+		fmt.Sprintf("Test root: %s\n", testFolder) +
+		"Test files:\n" +
+		"\t000_script_test.sh\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Statuses of sub-tests.
+const (
+	subTestPassed  = "passed"
+	subTestFailed  = "failed"
+	subTestSkipped = "skipped"
+	subTestTodo    = "todo"
+)
+
+var (
+	// tapPlanRe matches the plan of a TAP stream, such as "1..12", with the
+	// reason when all the tests are skipped, such as "1..0 # SKIP no CF".
+	tapPlanRe = regexp.MustCompile(`^1\.\.(\d+)\s*(?:#\s*(?i:skip)\S*\s*(.*))?$`)
+	// tapTestRe matches the test lines of a TAP stream, such as
+	// "not ok 3 - pushes the app # TODO not implemented yet".
+	tapTestRe = regexp.MustCompile(`^(not )?ok\b\s*(\d+)?\s*(?:-\s*)?(.*?)\s*(?:#\s*((?i:skip|todo))\S*\s*(.*))?$`)
+	// tapBailOutRe matches the line of a TAP stream giving up on the tests.
+	tapBailOutRe = regexp.MustCompile(`^Bail out!\s*(.*)$`)
+)
+
+// SubTestResult contains the result of one of the checks of a test script,
+// as reported by the TAP it printed.
+type SubTestResult struct {
+	Number      int    `json:"number"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	// Reason is the reason given for skipped and todo sub-tests.
+	Reason string `json:"reason,omitempty"`
+	// Diagnostics holds the YAML block following the sub-test, if any.
+	Diagnostics string `json:"diagnostics,omitempty"`
+}
+
+func (result SubTestResult) String() string {
+	var label string
+	switch result.Status {
+	case subTestPassed:
+		label = greenBold("PASSED")
+	case subTestFailed:
+		label = redBold("FAILED")
+	case subTestSkipped:
+		label = yellowBold("SKIPPED")
+	default:
+		label = yellowBold("TODO")
+	}
+	line := fmt.Sprintf("  %s: %d - %s", label, result.Number, result.Description)
+	if result.Reason != "" {
+		line += ": " + result.Reason
+	}
+	return line + "\n"
+}
+
+// subTestsString returns the lines describing the sub-tests of a test.
+func subTestsString(subTests []SubTestResult) string {
+	var lines bytes.Buffer
+	for _, subTest := range subTests {
+		lines.WriteString(subTest.String())
+	}
+	return lines.String()
+}
+
+// failedSubTests returns the number of sub-tests of a test that failed.
+func (result TestResult) failedSubTests() int {
+	failed := 0
+	for _, subTest := range result.SubTests {
+		if subTest.Status == subTestFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// parsesTAP tells whether the TAP a test prints on its stdout is parsed into
+// sub-tests, which the test opts into with the --parse-tap flag or the
+// tap=true metadata.
+func (r *Runner) parsesTAP(testFile string) bool {
+	if r.isHookScript(testFile) {
+		return false
+	}
+	if r.options.ParseTAP {
+		return true
+	}
+	metadata := r.testMetadata(testFile)
+	return metadata != nil && metadata.TAP
+}
+
+// parseTAP parses the TAP printed by a test into the results of its
+// sub-tests.  Lines that are not TAP are ignored, as are nested sub-tests.
+// Giving up with "Bail out!", and running fewer or more sub-tests than
+// planned, are reported as failed sub-tests.
+func parseTAP(output string) []SubTestResult {
+	var subTests []SubTestResult
+	planned := -1
+	inYAML := false
+	var yaml bytes.Buffer
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inYAML {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "..." {
+				subTests[len(subTests)-1].Diagnostics = yaml.String()
+				inYAML = false
+				continue
+			}
+			yaml.WriteString(strings.TrimPrefix(line, "  "))
+			yaml.WriteString("\n")
+			continue
+		}
+		if strings.TrimSpace(line) == "---" && strings.HasPrefix(line, " ") && len(subTests) > 0 {
+			inYAML = true
+			yaml.Reset()
+			continue
+		}
+
+		if match := tapPlanRe.FindStringSubmatch(line); match != nil {
+			planned, _ = strconv.Atoi(match[1])
+			if planned == 0 && match[2] != "" {
+				subTests = append(subTests, SubTestResult{
+					Description: "all sub-tests skipped",
+					Status:      subTestSkipped,
+					Reason:      match[2],
+				})
+			}
+		} else if match := tapTestRe.FindStringSubmatch(line); match != nil {
+			subTest := SubTestResult{
+				Number:      len(subTests) + 1,
+				Description: match[3],
+				Status:      subTestPassed,
+				Reason:      match[5],
+			}
+			if match[2] != "" {
+				subTest.Number, _ = strconv.Atoi(match[2])
+			}
+			if match[1] != "" {
+				subTest.Status = subTestFailed
+			}
+			switch strings.ToLower(match[4]) {
+			case "skip":
+				subTest.Status = subTestSkipped
+			case "todo":
+				// Failures of todo sub-tests are expected.
+				subTest.Status = subTestTodo
+			}
+			subTests = append(subTests, subTest)
+		} else if match := tapBailOutRe.FindStringSubmatch(line); match != nil {
+			subTests = append(subTests, SubTestResult{
+				Number:      len(subTests) + 1,
+				Description: "Bail out!",
+				Status:      subTestFailed,
+				Reason:      match[1],
+			})
+			return subTests
+		}
+	}
+	if inYAML {
+		// The YAML block was not closed, keep what was there.
+		subTests[len(subTests)-1].Diagnostics = yaml.String()
+	}
+
+	if planned > 0 && planned != len(subTests) {
+		subTests = append(subTests, SubTestResult{
+			Number:      len(subTests) + 1,
+			Description: fmt.Sprintf("planned %d sub-tests, but %d ran", planned, len(subTests)),
+			Status:      subTestFailed,
+		})
+	}
+	return subTests
+}
@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTAP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		output   string
+		expected []SubTestResult
+	}{
+		{
+			output: "TAP version 13\n" +
+				"1..3\n" +
+				"ok 1 - logs in\n" +
+				"Some other output\n" +
+				"not ok 2 - pushes the app\n" +
+				"  ---\n" +
+				"  message: push failed\n" +
+				"  ...\n" +
+				"# a comment\n" +
+				"ok 3 scales # skip no quota\n",
+			expected: []SubTestResult{
+				{Number: 1, Description: "logs in", Status: subTestPassed},
+				{Number: 2, Description: "pushes the app", Status: subTestFailed, Diagnostics: "message: push failed\n"},
+				{Number: 3, Description: "scales", Status: subTestSkipped, Reason: "no quota"},
+			},
+		},
+		{
+			output: "ok\n" +
+				"not ok - deletes # TODO not implemented\n" +
+				"    ok 1 - nested\n" +
+				"ok - has a # in it\n" +
+				"1..3\n",
+			expected: []SubTestResult{
+				{Number: 1, Description: "", Status: subTestPassed},
+				{Number: 2, Description: "deletes", Status: subTestTodo, Reason: "not implemented"},
+				{Number: 3, Description: "has a # in it", Status: subTestPassed},
+			},
+		},
+		{
+			output: "1..0 # Skipped: no CF_DOMAIN\n",
+			expected: []SubTestResult{
+				{Description: "all sub-tests skipped", Status: subTestSkipped, Reason: "no CF_DOMAIN"},
+			},
+		},
+		{
+			output: "1..3\n" +
+				"ok 1 - logs in\n" +
+				"Bail out! Cannot reach the API\n" +
+				"ok 2 - never seen\n",
+			expected: []SubTestResult{
+				{Number: 1, Description: "logs in", Status: subTestPassed},
+				{Number: 2, Description: "Bail out!", Status: subTestFailed, Reason: "Cannot reach the API"},
+			},
+		},
+		{
+			output: "1..3\n" +
+				"ok 1 - logs in\n",
+			expected: []SubTestResult{
+				{Number: 1, Description: "logs in", Status: subTestPassed},
+				{Number: 2, Description: "planned 3 sub-tests, but 1 ran", Status: subTestFailed},
+			},
+		},
+		{
+			output:   "Hello World!\n",
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		if subTests := parseTAP(tt.output); !reflect.DeepEqual(tt.expected, subTests) {
+			t.Errorf("\nExpected sub-tests of %q:\n%+v\nHave:\n%+v\n", tt.output, tt.expected, subTests)
+		}
+	}
+}
+
+func TestRunCommandSubTests(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/subtests")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err == nil || err.Error() != "1 tests failed" {
+		t.Errorf("Expected 1 test to fail, have %v", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 3 test files\n" +
+		"Running test checks_test.sh (1/3)\n" +
+		"FAILED: checks_test.sh (DURATION)\n" +
+		"  PASSED: 1 - logs in\n" +
+		"  FAILED: 2 - pushes the app\n" +
+		"  SKIPPED: 3 - scales the app: no quota\n" +
+		"  TODO: 4 - deletes the app: not implemented\n\n" +
+		"Test output:\n" +
+		"TAP version 13\n" +
+		"1..4\n" +
+		"ok 1 - logs in\n" +
+		"not ok 2 - pushes the app\n" +
+		"  ---\n" +
+		"  message: push failed\n" +
+		"  ...\n" +
+		"ok 3 - scales the app # SKIP no quota\n" +
+		"not ok 4 - deletes the app # TODO not implemented\n" +
+		"Running test passing_test.sh (2/3)\n" +
+		"PASSED: passing_test.sh (DURATION)\n" +
+		"  PASSED: 1 - logs in\n" +
+		"  PASSED: 2 - logs out\n\n" +
+		"Running test plain_test.sh (3/3)\n" +
+		"PASSED: plain_test.sh (DURATION)\n\n" +
+		"Tests complete: 2 Passed, 0 Skipped, 1 Failed\n\n" +
+		"  Failed tests:\n" +
+		"    checks_test.sh with 1 failed sub-tests (DURATION)\n" +
+		"      2 - pushes the app\n\n"
+	if stdoutStr := stripDurations(string(stdoutBytes)); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestRunCommandSubTestsJSON(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/subtests")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.JSONOutput = true
+	r.options.ParseTAP = true
+	r.options.TestTargets = []string{filepath.Join(testFolder, "plain_test.sh")}
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	var results jsonResults
+	if err := json.NewDecoder(&stdout).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.FailedList) != 1 {
+		t.Fatalf("Expected 1 failed test, have %+v", results)
+	}
+	failed := results.FailedList[0]
+	expected := []SubTestResult{
+		{Number: 1, Description: "only parsed with --parse-tap", Status: subTestFailed},
+	}
+	if failed.ExitCode != 0 || !reflect.DeepEqual(failed.SubTests, expected) {
+		t.Errorf("\nExpected exit code 0 and sub-tests:\n%+v\nHave exit code %d and:\n%+v\n", expected, failed.ExitCode, failed.SubTests)
+	}
+}
+
+func TestJUnitReportSubTests(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.InOrder = true
+	results := newResults(nil, false)
+	results.add("cf/checks_test.sh", testRun{
+		result: TestResult{
+			TestFile: "cf/checks_test.sh",
+			SubTests: []SubTestResult{
+				{Number: 1, Description: "logs in", Status: subTestPassed},
+				{Number: 2, Description: "pushes", Status: subTestFailed, Diagnostics: "message: push failed\n"},
+				{Number: 3, Description: "scales", Status: subTestSkipped, Reason: "no quota"},
+			},
+		},
+		exitCode: failedSubTestsExitCode,
+		started:  true,
+	})
+
+	// The sub-tests count as test cases of their own, on top of the test.
+	suite := r.junitReport(results).Suites[0]
+	if suite.Tests != 4 || suite.Failures != 2 || suite.Skipped != 1 {
+		t.Errorf("Expected 4 tests, 2 failures and 1 skipped, have %d, %d and %d", suite.Tests, suite.Failures, suite.Skipped)
+	}
+	expected := []junitTestCase{
+		{
+			Name:      "cf/checks_test.sh",
+			ClassName: "cf",
+			Time:      "0.000",
+			Failure:   &junitFailure{Message: "Failed with 1 failed sub-tests", Type: "exitcode", Value: "0"},
+		},
+		{Name: "1 - logs in", ClassName: "cf.checks_test.sh", Time: "0.000"},
+		{
+			Name:      "2 - pushes",
+			ClassName: "cf.checks_test.sh",
+			Time:      "0.000",
+			Failure:   &junitFailure{Message: "Sub-test failed", Type: "tap"},
+			SystemOut: "message: push failed\n",
+		},
+		{Name: "3 - scales", ClassName: "cf.checks_test.sh", Time: "0.000", Skipped: &junitSkipped{Message: "Skipped, no quota"}},
+	}
+	if !reflect.DeepEqual(suite.TestCases, expected) {
+		t.Errorf("\nExpected test cases:\n%+v\nHave:\n%+v\n", expected, suite.TestCases)
+	}
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tagExpression is a boolean expression over the tags of a test, such as
+// "smoke && !slow".  Tags are combined with && (and), || (or), ! (not) and
+// parentheses; && binds tighter than ||.
+type tagExpression interface {
+	matches(tags map[string]bool) bool
+}
+
+type tagName string
+
+func (e tagName) matches(tags map[string]bool) bool {
+	return tags[string(e)]
+}
+
+type tagNot struct {
+	expr tagExpression
+}
+
+func (e tagNot) matches(tags map[string]bool) bool {
+	return !e.expr.matches(tags)
+}
+
+type tagAnd struct {
+	left, right tagExpression
+}
+
+func (e tagAnd) matches(tags map[string]bool) bool {
+	return e.left.matches(tags) && e.right.matches(tags)
+}
+
+type tagOr struct {
+	left, right tagExpression
+}
+
+func (e tagOr) matches(tags map[string]bool) bool {
+	return e.left.matches(tags) || e.right.matches(tags)
+}
+
+// matchTags tells whether the tags of a test match the expression.
+func matchTags(expr tagExpression, tags []string) bool {
+	tagSet := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tagSet[tag] = true
+	}
+	return expr.matches(tagSet)
+}
+
+// parseTagExpression parses a tag expression.
+func parseTagExpression(input string) (tagExpression, error) {
+	tokens, err := tokenizeTagExpression(input)
+	if err != nil {
+		return nil, err
+	}
+	parser := &tagParser{tokens: tokens}
+	expr, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("Unexpected %q", parser.tokens[parser.pos])
+	}
+	return expr, nil
+}
+
+func tokenizeTagExpression(input string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(input); {
+		switch c := input[i]; {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(' || c == ')' || c == '!':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.HasPrefix(input[i:], "&&") || strings.HasPrefix(input[i:], "||"):
+			tokens = append(tokens, input[i:i+2])
+			i += 2
+		case c == '&' || c == '|':
+			return nil, fmt.Errorf("Unexpected %q at position %d, did you mean %q?", c, i, input[i:i+1]+input[i:i+1])
+		default:
+			start := i
+			for i < len(input) && !strings.ContainsRune(" \t\n()!&|", rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, input[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+// tagParser is a recursive descent parser for tag expressions.
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) parseOr() (tagExpression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = tagOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseAnd() (tagExpression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = tagAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseUnary() (tagExpression, error) {
+	token := p.peek()
+	switch token {
+	case "":
+		return nil, fmt.Errorf("Unexpected end of expression")
+	case "!":
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{expr}, nil
+	case "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("Missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case ")", "&&", "||":
+		return nil, fmt.Errorf("Unexpected %q", token)
+	}
+	p.pos++
+	return tagName(token), nil
+}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchTags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		tags     []string
+		expected bool
+	}{
+		{expr: "smoke", tags: []string{"smoke", "cf"}, expected: true},
+		{expr: "smoke", tags: []string{"cf"}, expected: false},
+		{expr: "smoke", tags: nil, expected: false},
+		{expr: "!slow", tags: nil, expected: true},
+		{expr: "smoke && !slow", tags: []string{"smoke"}, expected: true},
+		{expr: "smoke && !slow", tags: []string{"smoke", "slow"}, expected: false},
+		{expr: "smoke || cf", tags: []string{"cf"}, expected: true},
+		{expr: "smoke || cf && slow", tags: []string{"smoke"}, expected: true},
+		{expr: "(smoke || cf) && slow", tags: []string{"smoke"}, expected: false},
+		{expr: "!(smoke || cf)", tags: []string{"k8s"}, expected: true},
+		{expr: "!!smoke", tags: []string{"smoke"}, expected: true},
+		{expr: "  cf&&!slow ", tags: []string{"cf"}, expected: true},
+	}
+	for _, tt := range tests {
+		expr, err := parseTagExpression(tt.expr)
+		if err != nil {
+			t.Errorf("Error parsing %q: %s", tt.expr, err)
+			continue
+		}
+		if matched := matchTags(expr, tt.tags); matched != tt.expected {
+			t.Errorf("Expected %q matching %v to be %v, have %v", tt.expr, tt.tags, tt.expected, matched)
+		}
+	}
+}
+
+func TestParseTagExpressionErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		expected string
+	}{
+		{expr: "", expected: "Unexpected end of expression"},
+		{expr: "smoke &&", expected: "Unexpected end of expression"},
+		{expr: "smoke & slow", expected: "Unexpected '&' at position 6, did you mean \"&&\"?"},
+		{expr: "smoke slow", expected: "Unexpected \"slow\""},
+		{expr: "(smoke || cf", expected: "Missing closing parenthesis"},
+		{expr: "smoke)", expected: "Unexpected \")\""},
+		{expr: "|| smoke", expected: "Unexpected \"||\""},
+	}
+	for _, tt := range tests {
+		_, err := parseTagExpression(tt.expr)
+		if err == nil {
+			t.Errorf("Expected an error parsing %q", tt.expr)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("Expected error parsing %q to be %q, have %q", tt.expr, tt.expected, err)
+		}
+	}
+}
+
+func TestGetTestScripts_Tags(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/tags")
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.TestTargets = []string{testFolder}
+	r.options.TagsExpr = "smoke && !slow"
+
+	testRoot, testScripts, err := r.getTestScripts()
+	if err != nil {
+		t.Errorf("Error getting test scripts: %s", err)
+	}
+	if testFolder != testRoot {
+		t.Errorf("Test root %s was not %s", testRoot, testFolder)
+	}
+	expected := []string{"smoke_test.sh"}
+	if !reflect.DeepEqual(testScripts, expected) {
+		t.Errorf("\nExpected:\n%v\nHave:\n%v\n", expected, testScripts)
+	}
+	expectedFiltered := []FilteredTest{
+		{
+			TestFile: "slow_smoke_test.sh",
+			Reason:   `tags [smoke,slow] do not match --tags "smoke && !slow"`,
+		},
+		{
+			TestFile: "untagged_test.sh",
+			Reason:   `tags [] do not match --tags "smoke && !slow"`,
+		},
+	}
+	if !reflect.DeepEqual(r.filteredTests, expectedFiltered) {
+		t.Errorf("\nExpected filtered:\n%v\nHave:\n%v\n", expectedFiltered, r.filteredTests)
+	}
+}
+
+func TestGetTestScripts_InvalidTags(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/tags")
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.TestTargets = []string{testFolder}
+	r.options.TagsExpr = "smoke &&"
+
+	_, _, err := r.getTestScripts()
+	expected := `Error parsing tags expression "smoke &&": Unexpected end of expression`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, have %v", expected, err)
+	}
+}
+
+func TestRunCommandDryRunTags(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/tags")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.DryRun = true
+	r.options.TestTargets = []string{testFolder}
+	r.options.ExcludeReStr = "untagged"
+	r.options.TagsExpr = "smoke"
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "Found 2 test files\n" +
+		fmt.Sprintf("Test root: %s\n", testFolder) +
+		"Test files:\n" +
+		"\tslow_smoke_test.sh (tags=smoke,slow): tags match --tags \"smoke\"\n" +
+		"\tsmoke_test.sh (tags=smoke,cf): tags match --tags \"smoke\"\n" +
+		"Filtered out:\n" +
+		"\tuntagged_test.sh: path matches --exclude \"untagged\"\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
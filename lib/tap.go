@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tapStream writes the results of a run in the Test Anything Protocol,
+// version 13, as soon as they are known.  The tests are numbered in the order
+// they were started, and their lines are written in that order even when they
+// run concurrently.  It is safe for use by several goroutines.
+type tapStream struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	// next is the number of the next test line to write.
+	next int
+	// pending holds the lines of the tests done before the ones started
+	// earlier, by their number.
+	pending map[int]string
+}
+
+func newTAPStream(writer io.Writer) *tapStream {
+	return &tapStream{
+		writer:  writer,
+		next:    1,
+		pending: make(map[int]string),
+	}
+}
+
+// RunStart writes the header and the plan of the run.
+func (s *tapStream) RunStart(run RunInfo) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintf(s.writer, "TAP version 13\n1..%d\n", len(run.Tests))
+}
+
+func (s *tapStream) TestStart(int, string)     {}
+func (s *tapStream) TestOutput(string, []byte) {}
+
+// TestEnd writes the result of a test, once the ones started before it are
+// written.  The tests that were interrupted are left out, as the run bails
+// out.
+func (s *tapStream) TestEnd(test FinishedTest) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if test.Status == StatusNotRun {
+		return
+	}
+	s.pending[test.Index+1] = tapLine(test.Index+1, test)
+	for {
+		line, ok := s.pending[s.next]
+		if !ok {
+			return
+		}
+		fmt.Fprint(s.writer, line)
+		delete(s.pending, s.next)
+		s.next++
+	}
+}
+
+func (s *tapStream) HookStart(string, string) {}
+
+// HookEnd writes a diagnostic line for the hooks that failed, which TAP
+// consumers show as is.
+func (s *tapStream) HookEnd(result HookResult) {
+	if result.failed() {
+		s.comment(fmt.Sprintf("%s hook %s %s", result.Hook, result.TestFile, result.status()))
+	}
+}
+
+func (s *tapStream) comment(text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintf(s.writer, "# %s\n", text)
+}
+
+// RunEnd bails out when the run was interrupted, as the tests left are not
+// going to be reported.  The tests done after an interrupted one was started
+// are written first, in order.
+func (s *tapStream) RunEnd(results Results) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var numbers []int
+	for number := range s.pending {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		fmt.Fprint(s.writer, s.pending[number])
+		delete(s.pending, number)
+	}
+	if results.Interrupted {
+		fmt.Fprintf(s.writer, "Bail out! %s\n", ErrInterrupted)
+	}
+	return nil
+}
+
+// dryRun reports all the tests as skipped.
+func (s *tapStream) dryRun(testRoot string, testFiles []string) {
+	for i, testFile := range testFiles {
+		s.TestEnd(FinishedTest{
+			Index:    i,
+			Status:   StatusSkipped,
+			ExitCode: skipTestExitCode,
+			Result:   TestResult{TestFile: testFile, SkipReason: "dry run"},
+		})
+	}
+}
+
+// tapLine returns the test line of a result, followed by a YAML diagnostic
+// block with its exit code, duration and output when it failed.
+func tapLine(number int, test FinishedTest) string {
+	result := test.Result
+	switch test.Status {
+	case StatusSkipped:
+		if result.SkipReason != "" {
+			return fmt.Sprintf("ok %d - %s # SKIP %s\n", number, result.TestFile, result.SkipReason)
+		}
+		return fmt.Sprintf("ok %d - %s # SKIP\n", number, result.TestFile)
+	case StatusPassed, StatusFlaky:
+		return fmt.Sprintf("ok %d - %s\n", number, result.TestFile)
+	}
+
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "not ok %d - %s\n", number, result.TestFile)
+	fmt.Fprintf(&line, "  ---\n")
+	fmt.Fprintf(&line, "  exitcode: %d\n", test.ExitCode)
+	if failedSubTests := result.failedSubTests(); failedSubTests > 0 {
+		fmt.Fprintf(&line, "  failed_subtests: %d\n", failedSubTests)
+	}
+	fmt.Fprintf(&line, "  duration_ms: %d\n", formatDuration(result.Duration)/time.Millisecond)
+	if result.ResultsDir != "" {
+		fmt.Fprintf(&line, "  logs: %q\n", result.ResultsDir)
+	}
+	if len(result.Artifacts) > 0 {
+		fmt.Fprintf(&line, "  artifacts:\n")
+		for _, path := range result.artifactPaths() {
+			fmt.Fprintf(&line, "    - %q\n", path)
+		}
+	}
+	if result.Output == "" {
+		fmt.Fprintf(&line, "  output: \"\"\n")
+	} else {
+		fmt.Fprintf(&line, "  output: %s\n", yamlBlockIndicator(result.Output))
+		for _, outputLine := range strings.Split(strings.TrimSuffix(result.Output, "\n"), "\n") {
+			fmt.Fprintf(&line, "    %s\n", outputLine)
+		}
+	}
+	fmt.Fprintf(&line, "  ...\n")
+	return line.String()
+}
+
+// yamlBlockIndicator returns the header of a YAML literal block that keeps
+// the text as is: the indentation is given when the text starts with spaces,
+// and the line breaks at its end are kept or stripped as needed.
+func yamlBlockIndicator(text string) string {
+	indicator := "|"
+	if strings.HasPrefix(text, " ") {
+		indicator += "2"
+	}
+	if strings.HasSuffix(text, "\n\n") {
+		indicator += "+"
+	} else if !strings.HasSuffix(text, "\n") {
+		indicator += "-"
+	}
+	return indicator
+}
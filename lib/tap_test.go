@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// tapDurationRe matches the durations in TAP diagnostics.
+var tapDurationRe = regexp.MustCompile(`duration_ms: \d+`)
+
+func TestTAPLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		result   TestResult
+		exitCode int
+		expected string
+	}{
+		{
+			result:   TestResult{TestFile: "a_test.sh"},
+			exitCode: 0,
+			expected: "ok 1 - a_test.sh\n",
+		},
+		{
+			result:   TestResult{TestFile: "a_test.sh"},
+			exitCode: skipTestExitCode,
+			expected: "ok 1 - a_test.sh # SKIP\n",
+		},
+		{
+			result:   TestResult{TestFile: "a_test.sh", SkipReason: "no CF_DOMAIN"},
+			exitCode: skipTestExitCode,
+			expected: "ok 1 - a_test.sh # SKIP no CF_DOMAIN\n",
+		},
+		{
+			result:   TestResult{TestFile: "a_test.sh", Duration: 1500 * time.Millisecond, Output: "first\nsecond\n"},
+			exitCode: 3,
+			expected: "not ok 1 - a_test.sh\n" +
+				"  ---\n" +
+				"  exitcode: 3\n" +
+				"  duration_ms: 1500\n" +
+				"  output: |\n" +
+				"    first\n" +
+				"    second\n" +
+				"  ...\n",
+		},
+		{
+			result: TestResult{
+				TestFile:   "a_test.sh",
+				ResultsDir: "results/a_test.sh",
+				Artifacts:  []string{"artifacts/screenshot.png"},
+			},
+			exitCode: 1,
+			expected: "not ok 1 - a_test.sh\n" +
+				"  ---\n" +
+				"  exitcode: 1\n" +
+				"  duration_ms: 0\n" +
+				"  logs: \"results/a_test.sh\"\n" +
+				"  artifacts:\n" +
+				"    - \"results/a_test.sh/artifacts/screenshot.png\"\n" +
+				"  output: \"\"\n" +
+				"  ...\n",
+		},
+		{
+			result:   TestResult{TestFile: "a_test.sh", Output: "  indented\n\n"},
+			exitCode: unknownExitCode,
+			expected: "not ok 1 - a_test.sh\n" +
+				"  ---\n" +
+				"  exitcode: -1\n" +
+				"  duration_ms: 0\n" +
+				"  output: |2+\n" +
+				"      indented\n" +
+				"    \n" +
+				"  ...\n",
+		},
+		{
+			result:   TestResult{TestFile: "a_test.sh", Output: "no line break"},
+			exitCode: 1,
+			expected: "not ok 1 - a_test.sh\n" +
+				"  ---\n" +
+				"  exitcode: 1\n" +
+				"  duration_ms: 0\n" +
+				"  output: |-\n" +
+				"    no line break\n" +
+				"  ...\n",
+		},
+		{
+			result:   TestResult{TestFile: "a_test.sh"},
+			exitCode: 1,
+			expected: "not ok 1 - a_test.sh\n" +
+				"  ---\n" +
+				"  exitcode: 1\n" +
+				"  duration_ms: 0\n" +
+				"  output: \"\"\n" +
+				"  ...\n",
+		},
+	}
+	for _, tt := range tests {
+		if line := tapLine(1, newFinishedTest(0, tt.result, tt.exitCode)); line != tt.expected {
+			t.Errorf("\nExpected TAP line:\n%q\n\nHave:\n%q\n", tt.expected, line)
+		}
+	}
+}
+
+func TestTAPStreamOrder(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	tap := newTAPStream(&out)
+	tap.RunStart(RunInfo{Tests: []string{"a_test.sh", "b_test.sh", "c_test.sh", "d_test.sh"}})
+	for _, i := range []int{1, 3, 0} {
+		tap.TestEnd(FinishedTest{Index: i, Status: StatusPassed, Result: TestResult{TestFile: "test.sh"}})
+	}
+	tap.comment("still waiting for test 3")
+	tap.TestEnd(FinishedTest{Index: 2, Status: StatusNotRun, Result: TestResult{TestFile: "test.sh"}})
+	tap.RunEnd(Results{Interrupted: true})
+
+	expected := "TAP version 13\n" +
+		"1..4\n" +
+		"ok 1 - test.sh\n" +
+		"ok 2 - test.sh\n" +
+		"# still waiting for test 3\n" +
+		"ok 4 - test.sh\n" +
+		"Bail out! Test run interrupted\n"
+	if outStr := out.String(); outStr != expected {
+		t.Errorf("\nExpected TAP stream:\n%q\n\nHave:\n%q\n", expected, outStr)
+	}
+
+	// The tests done after an interrupted one are still written, in order.
+	out.Reset()
+	tap = newTAPStream(&out)
+	tap.RunStart(RunInfo{Tests: []string{"a_test.sh", "b_test.sh", "c_test.sh"}})
+	tap.TestEnd(FinishedTest{Index: 2, Status: StatusPassed, Result: TestResult{TestFile: "c_test.sh"}})
+	tap.TestEnd(FinishedTest{Index: 1, Status: StatusPassed, Result: TestResult{TestFile: "b_test.sh"}})
+	tap.TestEnd(FinishedTest{Index: 0, Status: StatusNotRun, Result: TestResult{TestFile: "a_test.sh"}})
+	tap.RunEnd(Results{Interrupted: true})
+
+	expected = "TAP version 13\n" +
+		"1..3\n" +
+		"ok 2 - b_test.sh\n" +
+		"ok 3 - c_test.sh\n" +
+		"Bail out! Test run interrupted\n"
+	if outStr := out.String(); outStr != expected {
+		t.Errorf("\nExpected TAP stream:\n%q\n\nHave:\n%q\n", expected, outStr)
+	}
+}
+
+func TestRunCommandTAP(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TAPOutput = true
+	r.options.Parallelism = 4
+	r.options.TestTargets = []string{filepath.Join(testFolder, "mixed"), filepath.Join(testFolder, "hooks")}
+	r.options.SetupScript = "setup.sh"
+	r.options.TeardownScript = "teardown.sh"
+	if err := r.RunCommand(); err == nil {
+		t.Error("Expected the run to fail")
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "TAP version 13\n" +
+		"1..6\n" +
+		"# setup hook hooks/broken/setup.sh failed with exit code 3\n" +
+		"ok 1 - hooks/broken/broken_test.sh # SKIP setup hooks/broken/setup.sh failed with exit code 3\n" +
+		"ok 2 - hooks/ok/ok_test.sh\n" +
+		"ok 3 - hooks/root_test.sh\n" +
+		"not ok 4 - mixed/fail_test.sh\n" +
+		"  ---\n" +
+		"  exitcode: 42\n" +
+		"  duration_ms: DURATION\n" +
+		"  output: |\n" +
+		"    Goodbye World!\n" +
+		"  ...\n" +
+		"ok 5 - mixed/skip_test.sh # SKIP Nothing to test here\n" +
+		"ok 6 - mixed/success_test.sh\n"
+	if stdoutStr := tapDurationRe.ReplaceAllString(string(stdoutBytes), "duration_ms: DURATION"); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestRunCommandDryRunTAP(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/mixed")
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.DryRun = true
+	r.options.TAPOutput = true
+	r.options.TestTargets = []string{testFolder}
+	if err := r.RunCommand(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	stdoutBytes, err := ioutil.ReadAll(&stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStdout := "TAP version 13\n" +
+		"1..3\n" +
+		"ok 1 - fail_test.sh # SKIP dry run\n" +
+		"ok 2 - skip_test.sh # SKIP dry run\n" +
+		"ok 3 - success_test.sh # SKIP dry run\n"
+	if stdoutStr := string(stdoutBytes); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// textReporter prints the progress and the results of a run as text.  While
+// the tests run concurrently, what it prints about a test or a hook is
+// buffered, and printed in one piece once it is done, so that the tests do not
+// interleave their output.
+type textReporter struct {
+	runner *Runner
+
+	// mutex guards running, and the writes to the runner's stdout while the
+	// tests run concurrently.
+	mutex sync.Mutex
+	// running holds the tests and hooks that were started and are not done
+	// yet, by their path.
+	running map[string]*textRunning
+}
+
+// textRunning is a test or a hook that is running.
+type textRunning struct {
+	out io.Writer
+	// buffer holds what is printed about it, while the tests run
+	// concurrently.
+	buffer         *bytes.Buffer
+	failedAttempts int
+}
+
+func newTextReporter(r *Runner) *textReporter {
+	return &textReporter{
+		runner:  r,
+		running: make(map[string]*textRunning),
+	}
+}
+
+func (t *textReporter) RunStart(run RunInfo) {
+	t.runner.printSelection(run.Tests)
+}
+
+// dryRun lists the tests that would run, with their metadata, and why the
+// others were left out.
+func (t *textReporter) dryRun(testRoot string, testFiles []string) {
+	r := t.runner
+	fmt.Fprintf(r.stdout, "Test root: %s\n", testRoot)
+	fmt.Fprintf(r.stdout, "Test files:\n")
+	for _, testFile := range testFiles {
+		line := testFile
+		if metadata := r.testMetadata(testFile); metadata != nil {
+			line += fmt.Sprintf(" (%s)", metadata)
+		}
+		if r.options.TagsExpr != "" {
+			line += fmt.Sprintf(": tags match --tags %q", r.options.TagsExpr)
+		}
+		fmt.Fprintf(r.stdout, "\t%s\n", line)
+	}
+	if hookScripts := r.findHooks(testRoot, testFiles).scripts(); len(hookScripts) > 0 {
+		fmt.Fprintf(r.stdout, "Hooks:\n")
+		for _, hookScript := range hookScripts {
+			fmt.Fprintf(r.stdout, "\t%s\n", hookScript)
+		}
+	}
+	if len(r.filteredTests) > 0 {
+		fmt.Fprintf(r.stdout, "Filtered out:\n")
+		for _, filteredTest := range r.filteredTests {
+			fmt.Fprintf(r.stdout, "\t%s: %s\n", filteredTest.TestFile, filteredTest.Reason)
+		}
+	}
+	r.printUnmetRequirements(testFiles)
+}
+
+// start returns where to print about a test or a hook that is started.
+func (t *textReporter) start(testFile string) io.Writer {
+	running := &textRunning{out: t.runner.stdout}
+	if t.runner.concurrent {
+		running.buffer = &bytes.Buffer{}
+		running.out = running.buffer
+	}
+	t.mutex.Lock()
+	t.running[testFile] = running
+	t.mutex.Unlock()
+	return running.out
+}
+
+// get returns a test or a hook that is running, or nil if it was not started.
+func (t *textReporter) get(testFile string) *textRunning {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.running[testFile]
+}
+
+// done prints what was buffered about a test or a hook that is done.
+func (t *textReporter) done(testFile string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if running := t.running[testFile]; running.buffer != nil {
+		io.Copy(t.runner.stdout, running.buffer)
+	}
+	delete(t.running, testFile)
+}
+
+func (t *textReporter) TestStart(index int, testFile string) {
+	out := t.start(testFile)
+	fmt.Fprintf(out, "Running test %s (%d/%d)\n", testFile, index+1, t.runner.roundTests)
+}
+
+// TestOutput prints the output of a test, or of a hook, as it runs in verbose
+// mode.  Otherwise it is only printed along with the results of failures.
+func (t *textReporter) TestOutput(testFile string, output []byte) {
+	if !t.runner.options.Verbose {
+		return
+	}
+	if running := t.get(testFile); running != nil {
+		running.out.Write(output)
+	}
+}
+
+// testStderr prints the stderr of a test, or of a hook, to stderr as it runs
+// in verbose mode, unless what is printed about the test is buffered.
+func (t *textReporter) testStderr(testFile string, output []byte) {
+	if !t.runner.options.Verbose {
+		return
+	}
+	if running := t.get(testFile); running != nil && running.buffer != nil {
+		running.out.Write(output)
+	} else if running != nil {
+		t.runner.stderr.Write(output)
+	}
+}
+
+func (t *textReporter) TestRetry(index int, attempt FailedResult) {
+	running := t.get(attempt.TestFile)
+	t.runner.printFailure(running.out, attempt)
+	running.failedAttempts++
+	fmt.Fprintf(running.out, "Retrying test %s (attempt %d/%d)\n", attempt.TestFile, running.failedAttempts+1, t.runner.options.Retries+1)
+}
+
+func (t *textReporter) TestEnd(test FinishedTest) {
+	result := test.Result
+	running := t.get(result.TestFile)
+	if running == nil {
+		// The test was skipped or failed without being run.
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if test.Status == StatusSkipped {
+			fmt.Fprintln(t.runner.stdout, SkippedResult(result))
+		} else {
+			fmt.Fprintln(t.runner.stdout, FailedResult{TestResult: result, ExitCode: test.ExitCode})
+		}
+		return
+	}
+
+	out := running.out
+	switch test.Status {
+	case StatusNotRun:
+		fmt.Fprintf(out, "%s: %s (%v)\n\n", yellowBold("INTERRUPTED"), result.TestFile, formatDuration(result.Duration))
+	case StatusSkipped:
+		fmt.Fprintln(out, SkippedResult(result))
+	case StatusFlaky:
+		fmt.Fprintln(out, FlakyResult(result))
+	case StatusPassed:
+		fmt.Fprintln(out, PassedResult(result))
+	default:
+		t.runner.printFailure(out, FailedResult{TestResult: result, ExitCode: test.ExitCode})
+	}
+	t.done(result.TestFile)
+}
+
+func (t *textReporter) HookStart(hook string, script string) {
+	out := t.start(script)
+	fmt.Fprintf(out, "Running %s hook %s\n", hook, script)
+}
+
+func (t *textReporter) HookEnd(result HookResult) {
+	running := t.get(result.TestFile)
+	out := running.out
+	fmt.Fprintln(out, result)
+	if result.ExitCode != 0 && !t.runner.options.Verbose {
+		fmt.Fprintln(out, "Hook output:")
+		fmt.Fprint(out, result.Output)
+	}
+	t.done(result.TestFile)
+}
+
+func (t *textReporter) RunEnd(results Results) error {
+	t.runner.outputResults(results)
+	return nil
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long the files have to stay unchanged before the
+// tests are run again, so that a burst of saves only triggers one run.
+const watchDebounce = 300 * time.Millisecond
+
+// WatchCommand is the entrypoint of the Runner to run the tests whenever
+// they change.  It runs all the tests once, then watches the test targets
+// and runs again the tests that changed, or all the tests of a directory
+// when another file of it, such as a helper or a hook, changed.  After each
+// run, it displays the latest results of every test.  It keeps going until
+// the runner is interrupted, and then returns ErrInterrupted.
+func (r *Runner) WatchCommand() error {
+	includeRe, err := regexp.Compile(r.options.IncludeReStr)
+	if err != nil {
+		err = fmt.Errorf("Error parsing files to include: %s", err)
+		fmt.Fprintln(r.stderr, redBold(err.Error()))
+		return err
+	}
+	testRoot, testFiles, err := r.getTestScriptsWithOrder()
+	if err != nil {
+		fmt.Fprintln(r.stderr, redBold(err.Error()))
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		err = fmt.Errorf("Error watching files: %s", err)
+		fmt.Fprintln(r.stderr, redBold(err.Error()))
+		return err
+	}
+	defer watcher.Close()
+	for _, testTarget := range r.options.TestTargets {
+		if err := r.watchTarget(watcher, testTarget); err != nil {
+			fmt.Fprintln(r.stderr, redBold(err.Error()))
+			return err
+		}
+	}
+
+	// latest holds the latest run of each test, by its path relative to the
+	// test root.
+	latest := make(map[string]testRun)
+	r.setupReporters()
+	r.runStart(testRoot, testFiles)
+	r.runWatchedTests(latest, testRoot, testFiles, testFiles)
+
+	changed := make(map[string]bool)
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod || r.ignoreWatchedFile(event.Name) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := r.watchTarget(watcher, event.Name); err != nil {
+						fmt.Fprintln(r.stderr, redBold(err.Error()))
+					}
+				}
+			}
+			changed[event.Name] = true
+			debounce = time.After(watchDebounce)
+		case err := <-watcher.Errors:
+			fmt.Fprintln(r.stderr, redBold(fmt.Sprintf("Error watching files: %s", err)))
+		case <-debounce:
+			debounce = nil
+			var changedPaths []string
+			for path := range changed {
+				changedPaths = append(changedPaths, path)
+			}
+			sort.Strings(changedPaths)
+			changed = make(map[string]bool)
+
+			fmt.Fprintf(r.stdout, "Changed: %s\n", strings.Join(changedPaths, ", "))
+			testRoot, testFiles, err = r.getTestScriptsWithOrder()
+			if err != nil {
+				fmt.Fprintln(r.stderr, redBold(err.Error()))
+				continue
+			}
+			affected := r.affectedTests(includeRe, testRoot, testFiles, changedPaths)
+			if len(affected) == 0 {
+				fmt.Fprintf(r.stdout, "No tests to run again\n\n")
+				continue
+			}
+			r.runWatchedTests(latest, testRoot, testFiles, affected)
+		case <-r.interrupted:
+			return ErrInterrupted
+		}
+	}
+}
+
+// runWatchedTests runs the affected tests out of all the testFiles, then
+// displays the latest results of all of them.
+func (r *Runner) runWatchedTests(latest map[string]testRun, testRoot string, testFiles []string, affected []string) {
+	runs, hookResults := r.runTests(affected, testRoot)
+	for i, run := range runs {
+		// The tests that did not complete keep their earlier result.
+		if run.started && run.exitCode != interruptedExitCode {
+			latest[affected[i]] = run
+		}
+	}
+
+	sortedFiles := append([]string(nil), testFiles...)
+	sort.Strings(sortedFiles)
+	results := newResults(hookResults, r.isInterrupted())
+	for _, testFile := range sortedFiles {
+		results.add(testFile, latest[testFile])
+	}
+	// The errors are printed, and the tests keep being watched.
+	r.runEnd(results)
+	if !r.isInterrupted() {
+		fmt.Fprintf(r.stdout, "Watching for changes, interrupt to stop\n\n")
+	}
+}
+
+// watchTarget watches a test target: all the directories beneath it when it
+// is a directory, or the directory of the test otherwise, as editors often
+// replace the files they save.
+func (r *Runner) watchTarget(watcher *fsnotify.Watcher, testTarget string) error {
+	path, err := filepath.Abs(testTarget)
+	if err != nil {
+		return fmt.Errorf("Error making %s absolute", testTarget)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Error watching %s: %s", path, err)
+	}
+	if !info.IsDir() {
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("Error watching %s: %s", filepath.Dir(path), err)
+		}
+		return nil
+	}
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if r.ignoreWatchedFile(path) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("Error watching %s: %s", path, err)
+		}
+		return nil
+	})
+}
+
+// ignoreWatchedFile tells whether changes to a file are ignored: hidden files
+// and directories, the backups editors keep, and the reports and the results
+// directory of the runs.
+func (r *Runner) ignoreWatchedFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return true
+	}
+	if r.options.ResultsDir != "" {
+		if resultsDir, err := filepath.Abs(r.options.ResultsDir); err == nil &&
+			(path == resultsDir || strings.HasPrefix(path, resultsDir+string(filepath.Separator))) {
+			return true
+		}
+	}
+	reportFiles := []string{r.options.JUnitFile, r.options.HTMLFile, r.options.MarkdownFile, r.options.StepSummaryFile}
+	for _, reportFile := range reportFiles {
+		if reportFile == "" {
+			continue
+		}
+		if reportPath, err := filepath.Abs(reportFile); err == nil && reportPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+// affectedTests returns the tests, out of testFiles and in the same order,
+// affected by the changed paths.  A changed test only affects itself, while
+// any other file, such as a helper or a hook, affects all the tests of its
+// directory and the ones beneath it.
+func (r *Runner) affectedTests(includeRe *regexp.Regexp, testRoot string, testFiles []string, changedPaths []string) []string {
+	isAffected := make(map[string]bool)
+	var affectedDirs []string
+	for _, path := range changedPaths {
+		relPath, err := filepath.Rel(testRoot, path)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			affectedDirs = append(affectedDirs, relPath)
+		} else if includeRe.MatchString(path) && !r.isHookScript(path) {
+			// Tests that were removed or filtered out are not run.
+			isAffected[relPath] = true
+		} else {
+			affectedDirs = append(affectedDirs, filepath.Dir(relPath))
+		}
+	}
+
+	var affected []string
+	for _, testFile := range testFiles {
+		if isAffected[testFile] {
+			affected = append(affected, testFile)
+			continue
+		}
+		for _, dir := range affectedDirs {
+			if dir == "." || strings.HasPrefix(testFile, dir+string(filepath.Separator)) {
+				affected = append(affected, testFile)
+				break
+			}
+		}
+	}
+	return affected
+}
@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAffectedTests(t *testing.T) {
+	t.Parallel()
+
+	testFolder, _ := filepath.Abs("../testdata/hooks")
+	testFiles := []string{"root_test.sh", "ok/ok_test.sh", "broken/broken_test.sh"}
+	tests := []struct {
+		changed  []string
+		expected []string
+	}{
+		{changed: []string{"ok/ok_test.sh"}, expected: []string{"ok/ok_test.sh"}},
+		{changed: []string{"ok/ok_test.sh", "root_test.sh"}, expected: []string{"root_test.sh", "ok/ok_test.sh"}},
+		{changed: []string{"ok/setup.sh"}, expected: []string{"ok/ok_test.sh"}},
+		{changed: []string{"broken/helper.sh"}, expected: []string{"broken/broken_test.sh"}},
+		{changed: []string{"teardown.sh"}, expected: testFiles},
+		{changed: []string{"ok"}, expected: []string{"ok/ok_test.sh"}},
+		{changed: []string{"ok/gone_test.sh"}, expected: nil},
+		{changed: []string{"../elsewhere.sh"}, expected: nil},
+	}
+	for _, tt := range tests {
+		r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+		var changedPaths []string
+		for _, changed := range tt.changed {
+			changedPaths = append(changedPaths, filepath.Join(testFolder, changed))
+		}
+		affected := r.affectedTests(regexp.MustCompile(r.options.IncludeReStr), testFolder, testFiles, changedPaths)
+		if !reflect.DeepEqual(tt.expected, affected) {
+			t.Errorf("\nExpected tests affected by %v:\n%v\nHave:\n%v\n", tt.changed, tt.expected, affected)
+		}
+	}
+}
+
+func TestIgnoreWatchedFile(t *testing.T) {
+	t.Parallel()
+
+	r := setupDefaultRunner(ioutil.Discard, ioutil.Discard)
+	r.options.JUnitFile = "report.xml"
+	r.options.HTMLFile = "report.html"
+	r.options.MarkdownFile = "report.md"
+	r.options.StepSummaryFile = "summary.md"
+	r.options.ResultsDir = "results"
+	junitPath, _ := filepath.Abs("report.xml")
+	htmlPath, _ := filepath.Abs("report.html")
+	markdownPath, _ := filepath.Abs("report.md")
+	stepSummaryPath, _ := filepath.Abs("summary.md")
+	resultsDir, _ := filepath.Abs("results")
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "/tests/a_test.sh", expected: false},
+		{path: "/tests/.a_test.sh.swp", expected: true},
+		{path: "/tests/a_test.sh~", expected: true},
+		{path: "/tests/.git", expected: true},
+		{path: junitPath, expected: true},
+		{path: htmlPath, expected: true},
+		{path: markdownPath, expected: true},
+		{path: stepSummaryPath, expected: true},
+		{path: resultsDir, expected: true},
+		{path: filepath.Join(resultsDir, "a_test.sh", "output.log"), expected: true},
+		{path: resultsDir + "-old", expected: false},
+	}
+	for _, tt := range tests {
+		if ignored := r.ignoreWatchedFile(tt.path); ignored != tt.expected {
+			t.Errorf("Expected changes to %s to be ignored: %v, have %v", tt.path, tt.expected, ignored)
+		}
+	}
+}
+
+// waitForOutput waits until the output contains the given text count times.
+func waitForOutput(t *testing.T, output *bytes.Buffer, stdout *concurrentBuffer, text string, count int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for strings.Count(output.String(), text) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q in output:\n%s", text, output.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+		stdoutBytes, _ := ioutil.ReadAll(stdout)
+		output.Write(stdoutBytes)
+	}
+}
+
+func TestWatchCommand(t *testing.T) {
+	t.Parallel()
+
+	testFolder, err := ioutil.TempDir("", "testbrain-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testFolder)
+	writeScript := func(name string, content string) {
+		path := filepath.Join(testFolder, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("#!/bin/bash\n"+content+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeScript("a_test.sh", "exit 0")
+	writeScript("sub/b_test.sh", "exit 0")
+	writeScript("sub/helper.sh", "")
+
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.InOrder = true
+	r.options.TestTargets = []string{testFolder}
+	done := make(chan error)
+	go func() {
+		done <- r.WatchCommand()
+	}()
+
+	var output bytes.Buffer
+	waitForOutput(t, &output, &stdout, "Watching for changes", 1)
+	writeScript("a_test.sh", "exit 3")
+	waitForOutput(t, &output, &stdout, "Watching for changes", 2)
+	writeScript("sub/helper.sh", "echo helper")
+	waitForOutput(t, &output, &stdout, "Watching for changes", 3)
+	r.Interrupt()
+	if err := <-done; err != ErrInterrupted {
+		t.Errorf("Expected the watch to be interrupted, have %v", err)
+	}
+
+	expectedStdout := "Found 2 test files\n" +
+		"Running test a_test.sh (1/2)\n" +
+		"PASSED: a_test.sh (DURATION)\n\n" +
+		"Running test sub/b_test.sh (2/2)\n" +
+		"PASSED: sub/b_test.sh (DURATION)\n\n" +
+		"Tests complete: 2 Passed, 0 Skipped, 0 Failed\n\n" +
+		"Watching for changes, interrupt to stop\n\n" +
+		"Changed: " + filepath.Join(testFolder, "a_test.sh") + "\n" +
+		"Running test a_test.sh (1/1)\n" +
+		"FAILED: a_test.sh (DURATION)\n\n" +
+		"Test output:\n" +
+		"Tests complete: 1 Passed, 0 Skipped, 1 Failed\n\n" +
+		"  Failed tests:\n" +
+		"    a_test.sh with exit code 3 (DURATION)\n\n" +
+		"Watching for changes, interrupt to stop\n\n" +
+		"Changed: " + filepath.Join(testFolder, "sub/helper.sh") + "\n" +
+		"Running test sub/b_test.sh (1/1)\n" +
+		"PASSED: sub/b_test.sh (DURATION)\n\n" +
+		"Tests complete: 1 Passed, 0 Skipped, 1 Failed\n\n" +
+		"  Failed tests:\n" +
+		"    a_test.sh with exit code 3 (DURATION)\n\n" +
+		"Watching for changes, interrupt to stop\n\n"
+	if stdoutStr := stripDurations(output.String()); stdoutStr != expectedStdout {
+		t.Errorf("\nExpected stdout:\n%q\n\nHave:\n%q\n", expectedStdout, stdoutStr)
+	}
+}
+
+func TestWatchCommandIgnoresReports(t *testing.T) {
+	t.Parallel()
+
+	testFolder, err := ioutil.TempDir("", "testbrain-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testFolder)
+	if err := ioutil.WriteFile(filepath.Join(testFolder, "a_test.sh"), []byte("#!/bin/bash\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout concurrentBuffer
+	r := setupDefaultRunner(&stdout, ioutil.Discard)
+	r.options.TestTargets = []string{testFolder}
+	// The reports are written inside the watched test root after each run.
+	r.options.HTMLFile = filepath.Join(testFolder, "report.html")
+	r.options.MarkdownFile = filepath.Join(testFolder, "report.md")
+	r.options.StepSummaryFile = filepath.Join(testFolder, "summary.md")
+	r.options.ResultsDir = filepath.Join(testFolder, "results")
+	done := make(chan error)
+	go func() {
+		done <- r.WatchCommand()
+	}()
+
+	var output bytes.Buffer
+	waitForOutput(t, &output, &stdout, "Watching for changes", 1)
+	time.Sleep(3 * watchDebounce)
+	stdoutBytes, _ := ioutil.ReadAll(&stdout)
+	output.Write(stdoutBytes)
+	r.Interrupt()
+	if err := <-done; err != ErrInterrupted {
+		t.Errorf("Expected the watch to be interrupted, have %v", err)
+	}
+
+	if runs := strings.Count(output.String(), "Tests complete"); runs != 1 {
+		t.Errorf("Expected the tests to run once, as writing the reports does not change them, have %d runs:\n%s", runs, output.String())
+	}
+}
@@ -0,0 +1,6 @@
+#!/bin/sh
+
+echo "Taking a screenshot"
+echo "Pretend this is a screenshot" > "$TESTBRAIN_ARTIFACTS_DIR/screenshot.txt"
+mkdir -p "$TESTBRAIN_ARTIFACTS_DIR/cf"
+echo "Pretend these are the logs of the app" > "$TESTBRAIN_ARTIFACTS_DIR/cf/app.log"
@@ -1,4 +1,5 @@
 #!/bin/bash
+# testbrain: requires=CF_DOMAIN,CF_USERNAME,CF_PASSWORD,ORG,SPACE executables=cf
 
 set -ex
 
@@ -0,0 +1,10 @@
+#!/bin/bash
+
+# Fails the first time it is run, and passes from then on.
+if [ -e "${FLAKY_MARKER}" ]; then
+    echo "Hello World!"
+    exit 0
+fi
+touch "${FLAKY_MARKER}"
+echo "Goodbye World!"
+exit 1
@@ -0,0 +1,4 @@
+#!/bin/bash
+
+echo "Cannot set up broken"
+exit 3
@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo "Tearing down ok"
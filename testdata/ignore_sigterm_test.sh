@@ -0,0 +1,7 @@
+#!/bin/bash
+
+trap '' TERM
+echo "Ignoring SIGTERM..."
+sleep 10000 &
+sleep 10000
+echo "Goodbye World!"
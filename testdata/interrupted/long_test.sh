@@ -0,0 +1 @@
+../timeout_test.sh
\ No newline at end of file
@@ -0,0 +1 @@
+../success/hello_world_test.sh
\ No newline at end of file
@@ -0,0 +1,6 @@
+#!/bin/bash
+
+# testbrain: owner=nobody
+# This is not in the header, as it comes after the first command.
+echo "Hello World!"
+# testbrain: tags=ignored
@@ -0,0 +1,6 @@
+#!/bin/bash
+# testbrain: timeout=1 tags=cf,slow owner=team-x
+# testbrain: requires=CF_DOMAIN flavor=vanilla
+
+echo "Timeout = $TESTBRAIN_TIMEOUT"
+sleep 10000
@@ -0,0 +1,7 @@
+#!/bin/bash
+
+# The child ignores SIGTERM, and does not hold on to the output of the test.
+(trap '' TERM; exec sleep 10000) >/dev/null 2>&1 &
+echo "Child $!"
+sleep 10000
+echo "Goodbye World!"
@@ -0,0 +1,4 @@
+#!/bin/bash
+# testbrain: requires=TESTBRAIN_UNSET_DOMAIN,HOME executables=sh,testbrain-missing-cf
+
+testbrain-missing-cf api "api.${TESTBRAIN_UNSET_DOMAIN}"
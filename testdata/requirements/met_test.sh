@@ -0,0 +1,4 @@
+#!/bin/bash
+# testbrain: requires=HOME executables=sh
+
+echo "Hello World!"
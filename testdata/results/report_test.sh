@@ -0,0 +1,8 @@
+#!/bin/bash
+
+echo '{"type": "value", "key": "region", "value": "eu-west"}' >> "$TESTBRAIN_RESULT_FILE"
+echo '{"type": "step", "name": "log in", "status": "passed"}' >> "$TESTBRAIN_RESULT_FILE"
+echo '{"type": "step", "name": "push the app", "status": "failed"}' >> "$TESTBRAIN_RESULT_FILE"
+echo '{"type": "warning", "message": "The API was slow"}' >> "$TESTBRAIN_RESULT_FILE"
+echo 'not JSON' >> "$TESTBRAIN_RESULT_FILE"
+exit 1
@@ -0,0 +1,4 @@
+#!/bin/bash
+
+echo '{"type": "skip", "reason": "No Docker registry available"}' >> "$TESTBRAIN_RESULT_FILE"
+exit 99
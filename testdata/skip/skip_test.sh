@@ -2,4 +2,5 @@
 
 echo "Something stdout" >&1
 echo "Something stderr" >&2
+[ -n "$TESTBRAIN_SKIP_REASON" ] && echo "Nothing to test here" > "$TESTBRAIN_SKIP_REASON"
 exit 99
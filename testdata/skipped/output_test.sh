@@ -0,0 +1,5 @@
+#!/bin/bash
+
+echo "Checking the quota"
+echo "No quota left for the test"
+exit 99
@@ -0,0 +1,5 @@
+#!/bin/bash
+
+echo "Checking the stemcell"
+echo "Only runs on SLE stemcells" > "$TESTBRAIN_SKIP_REASON"
+exit 99
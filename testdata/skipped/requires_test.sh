@@ -0,0 +1,5 @@
+#!/bin/bash
+# testbrain: requires=TESTBRAIN_UNSET_DOMAIN,TESTBRAIN_UNSET_USER
+
+echo "Running without a domain"
+exit 1
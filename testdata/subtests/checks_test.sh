@@ -0,0 +1,12 @@
+#!/bin/bash
+# testbrain: tap=true
+
+echo "TAP version 13"
+echo "1..4"
+echo "ok 1 - logs in"
+echo "not ok 2 - pushes the app"
+echo "  ---"
+echo "  message: push failed"
+echo "  ..."
+echo "ok 3 - scales the app # SKIP no quota"
+echo "not ok 4 - deletes the app # TODO not implemented"
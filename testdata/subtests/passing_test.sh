@@ -0,0 +1,6 @@
+#!/bin/bash
+# testbrain: tap=true
+
+echo "1..2"
+echo "ok 1 - logs in"
+echo "ok 2 - logs out"
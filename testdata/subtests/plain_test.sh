@@ -0,0 +1,3 @@
+#!/bin/bash
+
+echo "not ok 1 - only parsed with --parse-tap"
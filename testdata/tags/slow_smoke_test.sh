@@ -0,0 +1,5 @@
+#!/bin/bash
+# tags: smoke
+# testbrain: tags=slow
+
+echo "Slow smoke test"
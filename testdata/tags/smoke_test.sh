@@ -0,0 +1,4 @@
+#!/bin/bash
+# tags: smoke,cf
+
+echo "Smoke test"